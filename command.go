@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"shorten-url/utils"
+)
+
+const commandUsage = `Usage:
+  shorten-url [flags] [command]
+
+Commands:
+  serve                                       start the server, the default command
+  create [flags] <url>                        create a short url, see create -h
+  get <id>                                    show a short url
+  delete <id>...                              delete short urls and their clicks
+  list [-after <id>] [-limit <n>]             list short urls ordered by id
+  stats [-interval <i>] [-since <t>] <id>     show click stats of a short url
+  export [-o <file>]                          export every short url as JSON Lines
+  import [-f <file>] [-format <format>] [-on-conflict <policy>]
+                                              import an export of this or another shortener, see import -h
+  import-legacy [-f <file>]                   import a legacy urls.json, keeping ids and counts
+  purge-expired [-retention <d>]              delete short urls expired before the retention
+  migrate [status|up]                         inspect or apply schema migrations
+  apikey create [-admin] <name>               mint a new api key
+  apikey list                                 list api keys
+  apikey revoke <id>                          revoke an api key
+
+Settings are read from a YAML file (-config), env vars and flags, later ones taking precedence.`
+
+// run command from command line arguments, serving when there is none
+func runCommand(args []string) {
+	if len(args) == 0 {
+		serve()
+		return
+	}
+
+	switch args[0] {
+	case "serve":
+		if len(args) > 1 {
+			// flags are parsed before the command
+			fmt.Fprintln(os.Stderr, commandUsage)
+			os.Exit(2)
+		}
+		serve()
+	case "create", "get", "delete", "list", "stats", "export", "import", "import-legacy", "purge-expired":
+		store := openStore()
+		err := linkCommand(store, args[0], args[1:])
+		// commands return instead of exiting so that the store is always closed
+		store.Close()
+		exitOnError(err)
+	case "apikey":
+		store := openStore()
+		err := apiKeyCommand(store, args[1:])
+		store.Close()
+		exitOnError(err)
+	case "migrate":
+		exitOnError(migrateCommand(args[1:]))
+	default:
+		exitOnError(errCommandUsage)
+	}
+}
+
+var (
+	// the failures of the command were already logged
+	errFailed = errors.New("command failed")
+	// print the usage of all commands
+	errCommandUsage = errors.New("invalid command")
+	// the flag package already printed the error and usage
+	errInvalidFlags = errors.New("invalid flags")
+)
+
+// Parse flags of a command, -h returns flag.ErrHelp
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	err := flags.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return errInvalidFlags
+	}
+	return err
+}
+
+// wrong arguments of a command, holding its usage
+type usageError string
+
+func (err usageError) Error() string {
+	return "Usage: " + string(err)
+}
+
+// Exit with the status of a command error, 2 for wrong usage and 1 for failures
+func exitOnError(err error) {
+	var usage usageError
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	case errors.Is(err, errCommandUsage):
+		fmt.Fprintln(os.Stderr, commandUsage)
+		os.Exit(2)
+	case errors.As(err, &usage):
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	case errors.Is(err, errInvalidFlags):
+		os.Exit(2)
+	case errors.Is(err, errFailed):
+		os.Exit(1)
+	}
+	log.Println(err)
+	os.Exit(1)
+}
+
+func apiKeyCommand(store utils.Store, args []string) error {
+	if len(args) == 0 {
+		return errCommandUsage
+	}
+
+	switch args[0] {
+	case "create":
+		flags := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		admin := flags.Bool("admin", false, "allow the key to manage all links")
+		if err := parseFlags(flags, args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return usageError("apikey create [-admin] <name>")
+		}
+
+		rawKey, key, err := utils.CreateAPIKey(store, flags.Arg(0), *admin)
+		if err != nil {
+			return fmt.Errorf("error creating api key: %w", err)
+		}
+		fmt.Printf("Created api key %d (%s), admin: %t\n", key.ID, key.Name, key.Admin)
+		fmt.Println("Store it safely, it will not be shown again:")
+		fmt.Println(rawKey)
+	case "list":
+		keys, err := store.ListAPIKeys()
+		if err != nil {
+			return fmt.Errorf("error listing api keys: %w", err)
+		}
+		for _, key := range keys {
+			status := "active"
+			if key.RevokedAt != nil {
+				status = "revoked"
+			}
+			fmt.Printf("%d\t%s\tadmin=%t\t%s\t%s\n", key.ID, key.Name, key.Admin, key.CreatedAt.Format("2006-01-02 15:04:05"), status)
+		}
+	case "revoke":
+		if len(args) != 2 {
+			return usageError("apikey revoke <id>")
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid api key id: %s", args[1])
+		}
+		revoked, err := utils.RevokeAPIKey(store, id)
+		if err != nil {
+			return fmt.Errorf("error revoking api key: %w", err)
+		}
+		if !revoked {
+			return fmt.Errorf("no active api key with id %d", id)
+		}
+		fmt.Println("Revoked api key", id)
+	default:
+		return errCommandUsage
+	}
+	return nil
+}
+
+func migrateCommand(args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	if action != "up" && action != "status" {
+		return errCommandUsage
+	}
+
+	// open without preparing schema so that any version can be inspected
+	store, err := utils.OpenStore(config.Database.DSN())
+	if err != nil {
+		return fmt.Errorf("error opening store: %w", err)
+	}
+	defer store.Close()
+	migrator, ok := store.(utils.Migrator)
+	if !ok {
+		return errors.New("store does not support migrations")
+	}
+
+	status, err := migrator.SchemaStatus()
+	if err != nil {
+		return fmt.Errorf("error getting schema status: %w", err)
+	}
+	fmt.Printf("Current version: %d, latest version: %d\n", status.Current, status.Latest)
+	if status.Current > status.Latest {
+		return utils.ErrSchemaTooNew
+	}
+
+	switch action {
+	case "status":
+		for _, migration := range status.Pending {
+			fmt.Printf("Pending: %04d_%s\n", migration.Version, migration.Name)
+		}
+	case "up":
+		applied, err := migrator.Migrate()
+		for _, migration := range applied {
+			fmt.Printf("Applied: %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("error applying migrations: %w", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"shorten-url/utils"
+)
+
+// url data with the fields which the api hides
+type linkView struct {
+	*utils.URLData
+	Protected bool   `json:"protected"`
+	CreatedBy string `json:"createdBy,omitempty"`
+}
+
+func newLinkView(urlData *utils.URLData) linkView {
+	return linkView{URLData: urlData, Protected: urlData.IsProtected(), CreatedBy: urlData.CreatedBy}
+}
+
+// run link management command on the store
+func linkCommand(store utils.Store, name string, args []string) error {
+	switch name {
+	case "create":
+		return createCommand(store, args)
+	case "get":
+		if len(args) != 1 {
+			return usageError("get <id>")
+		}
+		urlData, err := getURLData(store, args[0])
+		if err != nil {
+			return err
+		}
+		return printJSON(newLinkView(urlData))
+	case "delete":
+		return deleteCommand(store, args)
+	case "list":
+		return listCommand(store, args)
+	case "stats":
+		return statsCommand(store, args)
+	case "export":
+		return exportCommand(store, args)
+	case "import":
+		return importCommand(store, args)
+	case "import-legacy":
+		return importLegacyCommand(store, args)
+	case "purge-expired":
+		flags := flag.NewFlagSet("purge-expired", flag.ContinueOnError)
+		retention := flags.Duration("retention", utils.PURGE_RETENTION, "how long an expired url is kept")
+		if err := parseFlags(flags, args); err != nil {
+			return err
+		}
+
+		n, err := store.PurgeExpired(time.Now().Add(-*retention))
+		if err != nil {
+			return fmt.Errorf("error purging expired urls: %w", err)
+		}
+		fmt.Println("Purged expired urls:", n)
+	}
+	return nil
+}
+
+func createCommand(store utils.Store, args []string) error {
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	custom := flags.String("custom", "", "custom short url")
+	ttl := flags.Int64("ttl", 0, "expire after the number of seconds")
+	expireAt := flags.String("expire-at", "", "expiry time in RFC 3339 format")
+	redirect := flags.Int("redirect", 0, "redirect status: 301, 302, 307 or 308, the server default when 0")
+	password := flags.String("password", "", "protect the url with a password")
+	owner := flags.Int64("owner", 0, "id of the api key owning the url")
+	withMeta := flags.Bool("meta", false, "serve link previews, fields which are not set are fetched from the page")
+	meta := utils.CustomMeta{}
+	flags.StringVar(&meta.Title, "title", "", "link preview title, implies -meta")
+	flags.StringVar(&meta.Description, "description", "", "link preview description, implies -meta")
+	flags.StringVar(&meta.ImageURL, "image", "", "link preview image url, implies -meta")
+	flags.StringVar(&meta.ThemeColor, "color", "", "link preview theme color, implies -meta")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageError("create [flags] <url>")
+	}
+
+	data := utils.CreateData{
+		URL:       utils.LongURL(flags.Arg(0)),
+		CustomURL: utils.ShortURL(*custom),
+		TTL:       *ttl,
+		Redirect:  *redirect,
+		Password:  *password,
+	}
+	if *expireAt != "" {
+		t, err := time.Parse(time.RFC3339, *expireAt)
+		if err != nil {
+			return fmt.Errorf("invalid expiry time, must be RFC 3339 format: %s", *expireAt)
+		}
+		data.ExpiredAt = &t
+	}
+	if *withMeta || meta != (utils.CustomMeta{}) {
+		data.Meta = &meta
+	}
+	if *owner != 0 {
+		key, err := findAPIKey(store, *owner)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return fmt.Errorf("no api key with id %d", *owner)
+		}
+		data.CreatedBy = key.Owner()
+	}
+
+	urlData, created, err := data.Shorten(context.Background(), store)
+	if err != nil {
+		return fmt.Errorf("error creating short url: %w", err)
+	}
+	if !created {
+		log.Println("Same short url already exists")
+	}
+	return printJSON(newLinkView(urlData))
+}
+
+func findAPIKey(store utils.Store, id int64) (*utils.APIKey, error) {
+	keys, err := store.ListAPIKeys()
+	if err != nil {
+		return nil, fmt.Errorf("error listing api keys: %w", err)
+	}
+	for _, key := range keys {
+		if key.ID == id {
+			return &key, nil
+		}
+	}
+	return nil, nil
+}
+
+func deleteCommand(store utils.Store, args []string) error {
+	if len(args) == 0 {
+		return usageError("delete <id>...")
+	}
+
+	failed := false
+	for _, id := range args {
+		urlData, err := utils.ShortURL(id).GetData(store)
+		if err == nil && urlData == nil {
+			log.Println("No short url with id", id)
+			failed = true
+			continue
+		}
+		if err == nil {
+			err = urlData.ShortURL.Delete(store)
+		}
+		if err != nil {
+			log.Println("Error deleting short url:", err)
+			failed = true
+			continue
+		}
+		fmt.Println("Deleted", id)
+	}
+	if failed {
+		return errFailed
+	}
+	return nil
+}
+
+func listCommand(store utils.Store, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	after := flags.String("after", "", "list urls after the id")
+	limit := flags.Int("limit", 100, "max number of urls, 0 lists all")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	n := 0
+	err := utils.EachURLData(store, utils.ShortURL(*after), func(urlData *utils.URLData) bool {
+		expiredAt := "-"
+		if urlData.ExpiredAt != nil {
+			expiredAt = urlData.ExpiredAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%s\t%s\tcount=%d\t%s\t%s\n", urlData.ShortURL, urlData.TargetURL, urlData.Count, urlData.CreatedAt.Format(time.RFC3339), expiredAt)
+		n++
+		return *limit <= 0 || n < *limit
+	})
+	if err != nil {
+		return fmt.Errorf("error listing short urls: %w", err)
+	}
+	return nil
+}
+
+func statsCommand(store utils.Store, args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	interval := flags.String("interval", "day", "bucket interval: hour, day or week")
+	sinceFlag := flags.String("since", "", "start time in RFC 3339 format, defaults to the range of the interval")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageError("stats [-interval <i>] [-since <t>] <id>")
+	}
+
+	var since time.Time
+	if *sinceFlag != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, *sinceFlag); err != nil {
+			return fmt.Errorf("invalid since time, must be RFC 3339 format: %s", *sinceFlag)
+		}
+	}
+	urlData, err := getURLData(store, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	stats, err := urlData.ShortURL.GetClickStats(store, *interval, since)
+	if err != nil {
+		return fmt.Errorf("error getting click stats: %w", err)
+	}
+	return printJSON(stats)
+}
+
+func exportCommand(store utils.Store, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "-", "output file, - for stdout")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("error creating export file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	n, err := utils.ExportURLs(store, out)
+	if err != nil {
+		return fmt.Errorf("error exporting short urls: %w", err)
+	}
+	log.Println("Exported short urls:", n)
+	return nil
+}
+
+func importCommand(store utils.Store, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	input := flags.String("f", "-", "file to import, - for stdin")
+	format := flags.String("format", "jsonl", "jsonl written by export, or a CSV or JSON export of bitly, yourls or shlink")
+	onConflict := flags.String("on-conflict", "skip", "what to do with a used id: skip, overwrite or fail")
+	dropOwners := flags.Bool("drop-owners", false, "drop the api key owners of jsonl links, when importing into another database")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return fmt.Errorf("error opening import file: %w", err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	var report *utils.ImportReport
+	var err error
+	if *format == "jsonl" {
+		report, err = utils.ImportURLs(store, in, utils.ConflictPolicy(*onConflict), *dropOwners)
+	} else {
+		report, err = utils.ImportExternalURLs(store, in, *format, utils.ConflictPolicy(*onConflict))
+	}
+	logImportReport(report)
+	if err != nil {
+		return fmt.Errorf("error importing short urls: %w", err)
+	}
+	if len(report.Rejected) > 0 {
+		return errFailed
+	}
+	return nil
+}
+
+func importLegacyCommand(store utils.Store, args []string) error {
+	flags := flag.NewFlagSet("import-legacy", flag.ContinueOnError)
+	input := flags.String("f", utils.DATA_PATH, "legacy urls.json file")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	report, err := utils.ImportLegacyFile(store, *input)
+	logImportReport(report)
+	if err != nil {
+		return fmt.Errorf("error importing legacy urls: %w", err)
+	}
+	if len(report.Rejected) > 0 || len(report.Collisions) > 0 {
+		return errFailed
+	}
+	return nil
+}
+
+// Import the configured legacy file once, renaming it when done
+func importLegacyOnStartup(store utils.Store) error {
+	path := config.Database.LegacyImport
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		// already imported
+		return nil
+	}
+
+	log.Println("Importing legacy urls:", path)
+	report, err := utils.ImportLegacyFile(store, path)
+	logImportReport(report)
+	if err != nil {
+		return fmt.Errorf("error importing legacy urls: %w", err)
+	}
+	if err := os.Rename(path, path+".imported"); err != nil {
+		return fmt.Errorf("error renaming imported legacy file: %w", err)
+	}
+	return nil
+}
+
+func logImportReport(report *utils.ImportReport) {
+	if report == nil {
+		return
+	}
+	for _, id := range report.Collisions {
+		log.Println("Collision:", id)
+	}
+	for _, rejected := range report.Rejected {
+		log.Println("Rejected:", rejected)
+	}
+	log.Printf("Created: %d, overwritten: %d, skipped: %d, rejected: %d\n",
+		report.Created, report.Overwritten, report.Skipped, len(report.Rejected))
+}
+
+func getURLData(store utils.Store, id string) (*utils.URLData, error) {
+	urlData, err := utils.ShortURL(id).GetData(store)
+	if err != nil {
+		return nil, fmt.Errorf("error getting short url: %w", err)
+	}
+	if urlData == nil {
+		return nil, fmt.Errorf("no short url with id %s", id)
+	}
+	return urlData, nil
+}
+
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("error encoding output: %w", err)
+	}
+	return nil
+}
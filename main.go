@@ -3,16 +3,19 @@ package main
 import (
 	"context"
 	"embed"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"shorten-url/utils"
 
-	"github.com/compose-spec/compose-go/dotenv"
 	"github.com/gin-gonic/gin"
 )
 
@@ -21,162 +24,64 @@ var GIT_COMMIT string
 //go:embed all:views
 var webViews embed.FS
 
-var (
-	HOST    string
-	PORT    string
-	SUPPORT string
-)
+// loaded on startup, see utils.Config for its sources
+var config *utils.Config
 
-func init() {
-	dotenv.Load()
-	HOST = os.Getenv("HOST")
-	if HOST == "" {
-		HOST = "0.0.0.0"
+func main() {
+	cfg, args, err := utils.LoadConfig(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, commandUsage)
+		return
 	}
-	PORT = os.Getenv("PORT")
-	if PORT == "" {
-		PORT = "8080"
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid config:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-	SUPPORT = os.Getenv("SUPPORT")
-	gin.SetMode(strings.ToLower(os.Getenv("GIN_MODE")))
+	cfg.Apply()
+	config = cfg
+	gin.SetMode(config.Server.Mode)
+
+	runCommand(args)
 }
 
-func main() {
+// Start the server and block until it is shut down by a signal
+func serve() {
 	log.Println("Git Commit:", GIT_COMMIT)
 
-	router := gin.Default()
-	router.LoadHTMLGlob("views/*.html")
-
-	router.NoRoute(func(c *gin.Context) {
-		if strings.HasPrefix(c.Request.URL.Path, "/api") {
-			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
-			c.Abort()
-		}
-	}, AddFileHandler(webViews))
-
-	router.Use(utils.RedirectLimiter).GET("/:id", func(ctx *gin.Context) {
-		shortenID := utils.ShortURL(strings.TrimSpace(ctx.Param("id")))
-		if urlData, err := shortenID.GetData(); urlData != nil {
-			urlData.IncreaseCount()
-			// no custom meta: header redirect
-			if urlData.Meta == nil {
-				ctx.Redirect(http.StatusTemporaryRedirect, string(urlData.TargetURL))
-				return
-			}
-			// has custom meta: js redirect
-			ctx.HTML(http.StatusOK, "redirect.html", gin.H{
-				"title":       urlData.Meta.Title,
-				"description": urlData.Meta.Description,
-				"image":       urlData.Meta.ImageURL,
-				"color":       urlData.Meta.ThemeColor,
-				"targetURL":   urlData.TargetURL,
-			})
-			return
-		} else if err != nil {
-			// server error
-			ctx.HTML(http.StatusInternalServerError, "500.html", gin.H{"support": SUPPORT})
-			return
-		}
-		// short url not found
-		ctx.HTML(http.StatusNotFound, "404.html", nil)
-	})
-
-	apiRouter := router.Group("/api")
-	apiRouter.Use(utils.ShortenLimiter).POST("/shorten", func(ctx *gin.Context) {
-		data := utils.CreateData{}
-		if err := ctx.BindJSON(&data); err != nil {
-			// check data is valid
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON"})
-			return
-		}
-		data.URL = utils.LongURL(strings.TrimSpace(string(data.URL)))
-		// check whether url is empty
-		if data.URL == "" {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "original URL is required"})
-			return
-		}
-		// check whether url format is valid
-		if err := data.URL.IsValid(); err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-		// check whether custom url has been used
-		data.CustomURL = utils.ShortURL(strings.TrimSpace(string(data.CustomURL)))
-		if data.CustomURL == "" {
-			// check whether meta data is same
-			if urlDate, err := data.URL.CheckMetaSame(data); urlDate != nil {
-				// data exists and same, return it
-				ctx.JSON(http.StatusOK, gin.H{
-					"short": string(urlDate.ShortURL),
-					"url":   data.URL,
-					"meta":  data.Meta})
-				return
-			} else if err != nil {
-				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
-				return
-			}
-		} else if err := data.CustomURL.IsValid(); err != nil {
-			// check whether shortURL format is valid
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		} else if old, err := data.CustomURL.GetData(); old != nil {
-			// check whether shortURL has been used
-			if data.URL != old.TargetURL || data.Meta != old.Meta {
-				// used
-				ctx.JSON(http.StatusBadRequest, gin.H{"error": "this custom url is already been used"})
-			} else {
-				// same as old, return it
-				ctx.JSON(http.StatusOK, old)
-			}
-			return
-		} else if err != nil {
-			// db error
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
-			return
-		}
-		// if has meta, fill meta field
-		if data.Meta != nil {
-			// check whether image url format is valid
-			if data.Meta.ImageURL != "" && !data.Meta.ImageURLIsValid() {
-				ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid image url"})
-				return
-			}
-			data.InsertMeta()
-		}
-
-		// create short url
-		urlDate, err := data.CreateShortURL()
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
-			return
-		}
-
-		ctx.JSON(http.StatusCreated, urlDate)
-	})
-
-	apiRouter.Use(utils.GetShortenLimiter).GET("/get/:id", func(ctx *gin.Context) {
-		shortenID := utils.ShortURL(strings.TrimSpace(ctx.Param("id")))
-		if urlData, err := shortenID.GetData(); urlData != nil {
-			ctx.JSON(http.StatusOK, urlData)
-			return
-		} else if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
-			return
-		}
-
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "not found"})
-	})
+	store := openStore()
+	// clicks are only recorded by the server
+	if err := utils.PrepareIPHashSalt(store); err != nil {
+		store.Close()
+		log.Fatalln("Error preparing ip hash salt:", err)
+	}
+	if err := importLegacyOnStartup(store); err != nil {
+		store.Close()
+		log.Fatalln(err)
+	}
+	router := newRouter(store)
 
 	gin.ForceConsoleColor()
 	srv := &http.Server{
-		Addr:    HOST + ":" + PORT,
+		Addr:    config.Server.Addr(),
 		Handler: router,
 	}
 
-	if gin.Mode() != gin.ReleaseMode {
-		srv.Addr = "127.0.0.1:8080"
+	var adminSrv *http.Server
+	if config.Server.MetricsAddr != "" {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/metrics", utils.MetricsHandler())
+		adminSrv = &http.Server{Addr: config.Server.MetricsAddr, Handler: adminMux}
+		go func() {
+			log.Println("Admin listening at:", adminSrv.Addr)
+			if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalln("An error occurred:", err)
+			}
+		}()
 	}
 
+	stopPurger := utils.StartPurger(store)
+
 	go func() {
 		log.Println("Server starting...")
 		log.Println("Listening at:", srv.Addr)
@@ -186,16 +91,55 @@ func main() {
 		}
 	}()
 
+	// docker stop sends SIGTERM
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
 	log.Println("Server shutting down...")
+	shuttingDown.Store(true)
+	time.Sleep(config.Server.ShutdownDrain)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// one deadline for every step, which bounds the whole shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
 	defer cancel()
-	utils.CloseDB()
+	clean := true
+	// stop accepting connections and wait for in-flight requests
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalln("Shutdown Error:", err)
+		log.Println("Shutdown Error:", err)
+		clean = false
+	}
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			log.Println("Error shutting down admin server:", err)
+		}
+	}
+	// wait for background workers
+	if err := stopPurger(ctx); err != nil {
+		log.Println("Error stopping purger:", err)
+		clean = false
+	}
+	// the store is only closed when nothing uses it anymore,
+	// otherwise it is left to the process exit
+	if !clean {
+		log.Println("Requests or workers are still running, store is not closed")
+	} else if err := store.Close(); err != nil {
+		log.Println("Error closing store:", err)
 	}
 	log.Println("Server has been shutdown.")
 }
+
+// set once shutdown begins, failing readiness checks
+var shuttingDown atomic.Bool
+
+// Open the configured store and make sure its schema is up to date
+func openStore() utils.Store {
+	store, err := utils.OpenStore(config.Database.DSN())
+	if err != nil {
+		log.Fatalln("Error opening store:", err)
+	}
+	if err := utils.PrepareSchema(store, config.Database.AutoMigrate); err != nil {
+		store.Close()
+		log.Fatalln("Error preparing database schema:", err)
+	}
+	return store
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"shorten-url/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bulk Shorten Result Data, either url data or an error
+type bulkResult struct {
+	Status int `json:"status"`
+	*utils.URLData
+	Error string `json:"error,omitempty"`
+}
+
+// key of a random url in a bulk request, urls with the same key are created once
+func bulkKey(data *utils.CreateData) string {
+	key, _ := json.Marshal([]interface{}{data.URL, data.Meta, data.ExpiredAt, data.Redirect, data.CreatedBy})
+	return string(key)
+}
+
+// count the click and redirect to the target url of the link
+func visitURL(store utils.Store, ctx *gin.Context, urlData *utils.URLData, status int) {
+	utils.RecordRedirect("redirect")
+	urlData.IncreaseCount(store)
+	urlData.RecordClick(store, ctx.Request.Referer(), ctx.Request.UserAgent(), ctx.ClientIP())
+	// no custom meta or not a bot: header redirect
+	if urlData.Meta == nil || !utils.IsBot(ctx.Request.UserAgent()) {
+		ctx.Redirect(status, string(urlData.TargetURL))
+		return
+	}
+	// has custom meta and requested by a bot: meta page with js redirect
+	ctx.HTML(http.StatusOK, "redirect.html", gin.H{
+		"title":       urlData.Meta.Title,
+		"description": urlData.Meta.Description,
+		"image":       urlData.Meta.ImageURL,
+		"color":       urlData.Meta.ThemeColor,
+		"targetURL":   urlData.TargetURL,
+	})
+}
+
+// show where the link leads to without counting a click
+func previewURL(store utils.Store, ctx *gin.Context, shortenID utils.ShortURL) {
+	urlData, err := shortenID.GetData(store)
+	if err != nil {
+		ctx.HTML(http.StatusInternalServerError, "500.html", gin.H{"support": config.Server.Support})
+		return
+	} else if urlData == nil {
+		ctx.HTML(http.StatusNotFound, "404.html", nil)
+		return
+	} else if urlData.IsExpired() {
+		ctx.HTML(http.StatusGone, "410.html", nil)
+		return
+	}
+
+	data := gin.H{
+		"short":     urlData.ShortURL,
+		"count":     urlData.Count,
+		"createdAt": urlData.CreatedAt.Format("2006-01-02 15:04 UTC"),
+		"protected": urlData.IsProtected(),
+	}
+	// destination of protected links is only shown after the password
+	if !urlData.IsProtected() {
+		data["targetURL"] = urlData.TargetURL
+		data["meta"] = urlData.Meta
+	}
+	ctx.HTML(http.StatusOK, "preview.html", data)
+}
+
+// Create router with all routes served by the given store
+func newRouter(store utils.Store) *gin.Engine {
+	router := gin.Default()
+	router.LoadHTMLGlob("views/*.html")
+	router.Use(utils.Metrics())
+
+	router.NoRoute(func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, "/api") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			c.Abort()
+		}
+	}, AddFileHandler(webViews))
+
+	// liveness, the process is able to serve requests
+	router.GET("/healthz", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	// readiness, the store is usable and the server is not shutting down
+	router.GET("/readyz", func(ctx *gin.Context) {
+		if shuttingDown.Load() {
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable"})
+			return
+		}
+		if err := utils.CheckReady(store); err != nil {
+			log.Println("Error checking readiness:", err)
+			// details are only logged, the endpoint is public
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable"})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	// metrics are served on the admin listener instead when it is set
+	if config.Server.MetricsAddr == "" {
+		router.GET("/metrics", gin.WrapH(utils.MetricsHandler()))
+	}
+
+	router.Use(utils.RedirectLimiter).GET("/:id", func(ctx *gin.Context) {
+		// "/:id+" is the same as "/:id/preview"
+		if id := strings.TrimSpace(ctx.Param("id")); strings.HasSuffix(id, "+") {
+			previewURL(store, ctx, utils.ShortURL(strings.TrimSuffix(id, "+")))
+			return
+		}
+		shortenID := utils.ShortURL(strings.TrimSpace(ctx.Param("id")))
+		if urlData, err := shortenID.GetData(store); urlData != nil {
+			if urlData.IsExpired() {
+				utils.RecordRedirect("expired")
+				ctx.HTML(http.StatusGone, "410.html", nil)
+				return
+			}
+			// protected: ask for password
+			if urlData.IsProtected() {
+				utils.RecordRedirect("protected")
+				ctx.HTML(http.StatusOK, "password.html", nil)
+				return
+			}
+			visitURL(store, ctx, urlData, urlData.RedirectStatus())
+			return
+		} else if err != nil {
+			// server error
+			utils.RecordRedirect("error")
+			ctx.HTML(http.StatusInternalServerError, "500.html", gin.H{"support": config.Server.Support})
+			return
+		}
+		// short url not found
+		utils.RecordRedirect("not_found")
+		ctx.HTML(http.StatusNotFound, "404.html", nil)
+	})
+
+	router.POST("/:id", func(ctx *gin.Context) {
+		shortenID := utils.ShortURL(strings.TrimSpace(ctx.Param("id")))
+		urlData, err := shortenID.GetData(store)
+		if err != nil {
+			ctx.HTML(http.StatusInternalServerError, "500.html", gin.H{"support": config.Server.Support})
+			return
+		} else if urlData == nil {
+			ctx.HTML(http.StatusNotFound, "404.html", nil)
+			return
+		} else if urlData.IsExpired() {
+			ctx.HTML(http.StatusGone, "410.html", nil)
+			return
+		} else if !urlData.IsProtected() {
+			ctx.Redirect(http.StatusSeeOther, ctx.Request.URL.Path)
+			return
+		}
+
+		// only failed attempts are limited
+		if utils.PasswordLimitReached(ctx, shortenID, ctx.ClientIP()) {
+			ctx.HTML(http.StatusTooManyRequests, "password.html", gin.H{"error": "Too many failed attempts, please try again later."})
+			return
+		}
+		if !urlData.PasswordMatches(ctx.PostForm("password")) {
+			utils.AddPasswordFailure(ctx, shortenID, ctx.ClientIP())
+			ctx.HTML(http.StatusUnauthorized, "password.html", gin.H{"error": "Incorrect password."})
+			return
+		}
+		// redirect the form submission with a GET request
+		visitURL(store, ctx, urlData, http.StatusSeeOther)
+	})
+
+	router.GET("/:id/preview", func(ctx *gin.Context) {
+		previewURL(store, ctx, utils.ShortURL(strings.TrimSpace(ctx.Param("id"))))
+	})
+
+	router.GET("/:id/qr", func(ctx *gin.Context) {
+		shortenID := utils.ShortURL(strings.TrimSpace(ctx.Param("id")))
+		urlData, err := shortenID.GetData(store)
+		if err != nil {
+			ctx.HTML(http.StatusInternalServerError, "500.html", gin.H{"support": config.Server.Support})
+			return
+		} else if urlData == nil {
+			ctx.HTML(http.StatusNotFound, "404.html", nil)
+			return
+		} else if urlData.IsExpired() {
+			ctx.HTML(http.StatusGone, "410.html", nil)
+			return
+		}
+
+		opts := utils.DefaultQROptions()
+		opts.Format = ctx.DefaultQuery("format", opts.Format)
+		opts.Level = ctx.DefaultQuery("level", opts.Level)
+		opts.Foreground = ctx.DefaultQuery("fg", opts.Foreground)
+		opts.Background = ctx.DefaultQuery("bg", opts.Background)
+		if opts.Size, err = strconv.Atoi(ctx.DefaultQuery("size", strconv.Itoa(opts.Size))); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid size"})
+			return
+		}
+		if opts.Margin, err = strconv.Atoi(ctx.DefaultQuery("margin", strconv.Itoa(opts.Margin))); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid margin"})
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := utils.WriteQRCode(&buf, shortLink(ctx, urlData.ShortURL), opts); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		contentType := "image/png"
+		if opts.Format == "svg" {
+			contentType = "image/svg+xml"
+		}
+		ctx.Header("Cache-Control", "public, max-age=86400")
+		ctx.Data(http.StatusOK, contentType, buf.Bytes())
+	})
+
+	apiRouter := router.Group("/api", utils.APIKeyAuth(store))
+	// registered before the shorten limiter is added to the group
+	apiRouter.POST("/preview", utils.PreviewLimiter, func(ctx *gin.Context) {
+		if utils.GetRequestAPIKey(ctx) == nil && !utils.ALLOW_ANONYMOUS {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "api key is required"})
+			return
+		}
+		data := utils.PreviewData{}
+		if err := ctx.BindJSON(&data); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON"})
+			return
+		}
+
+		meta, err := data.Preview(ctx.Request.Context())
+		var invalid *utils.ValidationError
+		if errors.As(err, &invalid) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			ctx.JSON(http.StatusBadGateway, gin.H{"error": "cannot fetch this url"})
+			return
+		}
+		ctx.JSON(http.StatusOK, meta)
+	})
+
+	apiRouter.Use(utils.ShortenLimiter).POST("/shorten", func(ctx *gin.Context) {
+		apiKey := utils.GetRequestAPIKey(ctx)
+		if apiKey == nil && !utils.ALLOW_ANONYMOUS {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "api key is required"})
+			return
+		}
+		data := utils.CreateData{}
+		if err := ctx.BindJSON(&data); err != nil {
+			// check data is valid
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON"})
+			return
+		}
+		if apiKey != nil {
+			data.CreatedBy = apiKey.Owner()
+		}
+		data.IP = ctx.ClientIP()
+		urlData, created, err := data.Shorten(ctx.Request.Context(), store)
+		if err != nil {
+			var invalid *utils.ValidationError
+			if errors.As(err, &invalid) {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			} else {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			}
+			return
+		} else if !created {
+			// data exists and same, return it
+			ctx.JSON(http.StatusOK, urlData)
+			return
+		}
+
+		ctx.JSON(http.StatusCreated, urlData)
+	})
+
+	apiRouter.POST("/shorten/bulk", utils.BulkLimiter, func(ctx *gin.Context) {
+		apiKey := utils.GetRequestAPIKey(ctx)
+		if apiKey == nil && !utils.ALLOW_ANONYMOUS {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "api key is required"})
+			return
+		}
+		datas := []utils.CreateData{}
+		if err := ctx.BindJSON(&datas); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON"})
+			return
+		}
+		if len(datas) == 0 || len(datas) > utils.BULK_MAX_SIZE {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "number of urls must be between 1 and " + strconv.Itoa(utils.BULK_MAX_SIZE)})
+			return
+		}
+
+		results := make([]bulkResult, len(datas))
+		pending := []*utils.CreateData{}
+		pendingIndex := []int{}
+		sameIndex := map[string]int{}
+		sameAs := map[int]int{}
+		for i := range datas {
+			data := &datas[i]
+			if apiKey != nil {
+				data.CreatedBy = apiKey.Owner()
+			}
+			// check whether data is valid and already exists
+			if old, err := data.Check(store); err != nil {
+				var invalid *utils.ValidationError
+				if errors.As(err, &invalid) {
+					results[i] = bulkResult{Status: http.StatusBadRequest, Error: err.Error()}
+				} else {
+					results[i] = bulkResult{Status: http.StatusInternalServerError, Error: "internal server error"}
+				}
+			} else if old != nil {
+				results[i] = bulkResult{Status: http.StatusOK, URLData: old}
+			} else if first, ok := sameIndex[bulkKey(data)]; ok && data.CustomURL == "" && data.Password == "" {
+				// same as an earlier url of this request
+				sameAs[i] = first
+			} else {
+				if data.CustomURL == "" && data.Password == "" {
+					sameIndex[bulkKey(data)] = i
+				}
+				pending = append(pending, data)
+				pendingIndex = append(pendingIndex, i)
+			}
+		}
+
+		// fill meta fields concurrently, within one deadline for the whole request,
+		// and stop fetching when the client goes away
+		metaCtx, cancel := context.WithTimeout(ctx.Request.Context(), utils.BULK_META_TIMEOUT)
+		defer cancel()
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, 8)
+		metaErrs := make([]error, len(pending))
+	fetch:
+		for j, data := range pending {
+			if data.Meta == nil {
+				continue
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-metaCtx.Done():
+				break fetch
+			}
+			wg.Add(1)
+			go func(j int, data *utils.CreateData) {
+				defer func() { <-sem; wg.Done() }()
+				metaErrs[j] = data.InsertMeta(metaCtx)
+			}(j, data)
+		}
+		wg.Wait()
+		if err := ctx.Request.Context().Err(); err != nil {
+			log.Println("Error shortening urls:", err)
+			return
+		}
+
+		checked, checkedIndex := []*utils.CreateData{}, []int{}
+		for j, data := range pending {
+			var invalid *utils.ValidationError
+			if errors.As(metaErrs[j], &invalid) {
+				results[pendingIndex[j]] = bulkResult{Status: http.StatusBadRequest, Error: invalid.Error()}
+				continue
+			}
+			checked, checkedIndex = append(checked, data), append(checkedIndex, pendingIndex[j])
+		}
+		pending, pendingIndex = checked, checkedIndex
+
+		urlDatas, errs, err := utils.CreateShortURLs(store, pending, ctx.ClientIP())
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		for j, i := range pendingIndex {
+			if errors.Is(errs[j], utils.ErrDuplicateID) {
+				results[i] = bulkResult{Status: http.StatusBadRequest, Error: "this custom url is already been used"}
+			} else if errs[j] != nil {
+				results[i] = bulkResult{Status: http.StatusInternalServerError, Error: "internal server error"}
+			} else {
+				results[i] = bulkResult{Status: http.StatusCreated, URLData: urlDatas[j]}
+			}
+		}
+		for i, first := range sameAs {
+			results[i] = results[first]
+			if results[i].Status == http.StatusCreated {
+				results[i].Status = http.StatusOK
+			}
+		}
+
+		ctx.JSON(http.StatusOK, results)
+	})
+
+	apiRouter.Use(utils.GetShortenLimiter).GET("/get/:id", func(ctx *gin.Context) {
+		shortenID := utils.ShortURL(strings.TrimSpace(ctx.Param("id")))
+		if urlData, err := shortenID.GetData(store); urlData != nil {
+			if urlData.IsExpired() {
+				ctx.JSON(http.StatusGone, gin.H{"error": "this url has expired"})
+				return
+			}
+			if urlData.IsProtected() && !utils.GetRequestAPIKey(ctx).CanAccess(urlData) {
+				ctx.JSON(http.StatusForbidden, gin.H{"error": "this url is password protected"})
+				return
+			}
+			ctx.JSON(http.StatusOK, urlData)
+			return
+		} else if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	})
+
+	apiRouter.GET("/stats/:id", func(ctx *gin.Context) {
+		shortenID := utils.ShortURL(strings.TrimSpace(ctx.Param("id")))
+		if urlData, err := shortenID.GetData(store); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		} else if urlData == nil {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		} else if urlData.CreatedBy != "" && !utils.GetRequestAPIKey(ctx).CanAccess(urlData) {
+			// stats of owned links are private to the owner
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+
+		var since time.Time
+		if s := ctx.Query("since"); s != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, s); err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid since time, must be RFC 3339 format"})
+				return
+			}
+		}
+		stats, err := shortenID.GetClickStats(store, ctx.Query("interval"), since)
+		if err != nil {
+			if errors.Is(err, utils.ErrInvalidInterval) {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			} else {
+				ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			}
+			return
+		}
+
+		ctx.JSON(http.StatusOK, stats)
+	})
+
+	apiRouter.PATCH("/links/:id", func(ctx *gin.Context) {
+		urlData := getManagedURLData(store, ctx)
+		if urlData == nil {
+			return
+		}
+
+		data := utils.UpdateData{}
+		if err := ctx.BindJSON(&data); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid JSON"})
+			return
+		}
+		if err := data.Apply(urlData); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := urlData.Update(store); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, urlData)
+	})
+
+	apiRouter.DELETE("/links/:id", func(ctx *gin.Context) {
+		urlData := getManagedURLData(store, ctx)
+		if urlData == nil {
+			return
+		}
+
+		if err := urlData.ShortURL.Delete(store); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	})
+
+	return router
+}
+
+// Get url data which the request api key is allowed to manage,
+// write the error response and return nil if not allowed
+func getManagedURLData(store utils.Store, ctx *gin.Context) *utils.URLData {
+	apiKey := utils.GetRequestAPIKey(ctx)
+	if apiKey == nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "api key is required"})
+		return nil
+	}
+
+	shortenID := utils.ShortURL(strings.TrimSpace(ctx.Param("id")))
+	urlData, err := shortenID.GetData(store)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+		return nil
+	}
+	if urlData == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return nil
+	}
+	if !apiKey.CanAccess(urlData) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return nil
+	}
+	return urlData
+}
+
+// Get the full short link of the id, under BASE_URL or as seen by the client
+func shortLink(ctx *gin.Context, id utils.ShortURL) string {
+	if utils.BASE_URL != "" {
+		return utils.BASE_URL + "/" + string(id)
+	}
+	scheme := "http"
+	if ctx.Request.TLS != nil || ctx.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + ctx.Request.Host + "/" + string(id)
+}
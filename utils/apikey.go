@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// whether links can be created without an api key
+var ALLOW_ANONYMOUS = true
+
+const (
+	API_KEY_PREFIX = "surl_"
+	// gin context key of the authenticated api key
+	apiKeyContextKey = "apiKey"
+)
+
+// API Key Data
+type APIKey struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Admin     bool       `json:"admin"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+}
+
+// Owner id stored in the created_by column of links created with this key
+func (key *APIKey) Owner() string {
+	return strconv.FormatInt(key.ID, 10)
+}
+
+// check if the key owns the short url or is an admin key
+func (key *APIKey) CanAccess(urlData *URLData) bool {
+	return key != nil && (key.Admin || urlData.CreatedBy == key.Owner())
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Mint a new api key, the raw key is only returned here and never stored
+func CreateAPIKey(store Store, name string, admin bool) (string, *APIKey, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	rawKey := API_KEY_PREFIX + hex.EncodeToString(buf)
+
+	key := &APIKey{Name: name, Admin: admin, CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	if err := store.CreateAPIKey(key, hashAPIKey(rawKey)); err != nil {
+		return "", nil, err
+	}
+	return rawKey, key, nil
+}
+
+// Get an active api key by its raw value
+func GetAPIKey(store Store, rawKey string) (*APIKey, error) {
+	return store.GetAPIKey(hashAPIKey(rawKey))
+}
+
+// Revoke an api key, return false if no active key has the id
+func RevokeAPIKey(store Store, id int64) (bool, error) {
+	return store.RevokeAPIKey(id, time.Now().UTC().Truncate(time.Second))
+}
+
+// Authenticate api key from `Authorization: Bearer <key>` or `X-API-Key` header.
+// Requests without a key pass through anonymously, and so do other authorization
+// schemes, e.g. basic auth of a proxy in front of the server.
+func APIKeyAuth(store Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rawKey := c.GetHeader("X-API-Key")
+		if auth := c.GetHeader("Authorization"); rawKey == "" && auth != "" {
+			scheme, token, _ := strings.Cut(strings.TrimSpace(auth), " ")
+			if strings.EqualFold(scheme, "Bearer") {
+				rawKey = strings.TrimSpace(token)
+				if rawKey == "" {
+					c.JSON(401, gin.H{"error": "invalid api key"})
+					c.Abort()
+					return
+				}
+			}
+		}
+		if rawKey == "" {
+			c.Next()
+			return
+		}
+
+		key, err := GetAPIKey(store, rawKey)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "internal server error"})
+			c.Abort()
+			return
+		}
+		if key == nil {
+			c.JSON(401, gin.H{"error": "invalid api key"})
+			c.Abort()
+			return
+		}
+		c.Set(apiKeyContextKey, key)
+		c.Next()
+	}
+}
+
+// Get the authenticated api key of the request, nil if anonymous
+func GetRequestAPIKey(c *gin.Context) *APIKey {
+	if key, ok := c.Get(apiKeyContextKey); ok {
+		return key.(*APIKey)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	store := NewMemoryStore()
+	rawKey, key, err := CreateAPIKey(store, "test", false)
+	if err != nil {
+		t.Fatalf("Api key is not created: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(APIKeyAuth(store))
+	router.GET("/", func(c *gin.Context) {
+		if key := GetRequestAPIKey(c); key != nil {
+			c.String(http.StatusOK, key.Owner())
+			return
+		}
+		c.String(http.StatusOK, "anonymous")
+	})
+
+	tests := []struct {
+		header, value string
+		code          int
+		body          string
+	}{
+		{"", "", http.StatusOK, "anonymous"},
+		{"Authorization", "Bearer " + rawKey, http.StatusOK, key.Owner()},
+		{"Authorization", "bearer  " + rawKey, http.StatusOK, key.Owner()},
+		{"X-API-Key", rawKey, http.StatusOK, key.Owner()},
+		{"Authorization", "Bearer wrong", http.StatusUnauthorized, ""},
+		{"Authorization", rawKey, http.StatusOK, "anonymous"},
+		{"Authorization", "Basic dXNlcjpwYXNz", http.StatusOK, "anonymous"},
+		{"Authorization", "Bearer", http.StatusUnauthorized, ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.code || (test.body != "" && w.Body.String() != test.body) {
+			t.Errorf("%s: %q is not correct: %d %s", test.header, test.value, w.Code, w.Body.String())
+		}
+	}
+
+	if ok, _ := RevokeAPIKey(store, key.ID); !ok {
+		t.Fatalf("Api key is not revoked")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+rawKey)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Revoked api key is accepted")
+	}
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"strings"
+)
+
+// user agent signatures of link unfurling bots, matched case-insensitively
+var BOT_USER_AGENTS = []string{
+	"Slackbot",
+	"Twitterbot",
+	"Discordbot",
+	"facebookexternalhit",
+	"Facebot",
+	"LinkedInBot",
+	"TelegramBot",
+	"WhatsApp",
+	"SkypeUriPreview",
+	"Pinterestbot",
+	"redditbot",
+	"Embedly",
+	"vkShare",
+	"Applebot",
+	"Mastodon",
+}
+
+// check if user agent belongs to a link unfurling bot
+func IsBot(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	for _, signature := range BOT_USER_AGENTS {
+		if strings.Contains(userAgent, strings.ToLower(signature)) {
+			return true
+		}
+	}
+	return false
+}
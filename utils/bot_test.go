@@ -0,0 +1,41 @@
+package utils
+
+import "testing"
+
+func TestIsBot(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		bot       bool
+	}{
+		{"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", true},
+		{"Twitterbot/1.0", true},
+		{"Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)", true},
+		{"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", true},
+		{"TelegramBot (like TwitterBot)", true},
+		{"WhatsApp/2.23.20.0", true},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", false},
+		{"curl/8.4.0", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if IsBot(test.userAgent) != test.bot {
+			t.Errorf("Bot detection of %q is not correct", test.userAgent)
+		}
+	}
+}
+
+func TestBotUserAgentsConfig(t *testing.T) {
+	defaults := BOT_USER_AGENTS
+	defer func() { BOT_USER_AGENTS = defaults }()
+
+	BOT_USER_AGENTS = splitList(" MyBot , ,other-preview")
+	if len(BOT_USER_AGENTS) != 2 {
+		t.Fatalf("Bot user agents are not parsed: %q", BOT_USER_AGENTS)
+	}
+	if !IsBot("Mozilla/5.0 (compatible; mybot/1.0)") || !IsBot("Other-Preview") {
+		t.Errorf("Configured bot is not detected")
+	}
+	if IsBot("Slackbot-LinkExpanding 1.0") {
+		t.Errorf("Default bot is still detected")
+	}
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// key of the HMAC of client ips, generated by PrepareIPHashSalt when empty
+var IP_HASH_SALT string
+
+// Click Event Data
+type ClickEvent struct {
+	ShortURL  ShortURL  `json:"short"`
+	ClickedAt time.Time `json:"clickedAt"`
+	Referrer  string    `json:"referrer"`
+	UserAgent string    `json:"userAgent"`
+	IPHash    string    `json:"ipHash"`
+	TargetURL LongURL   `json:"url"`
+}
+
+// Click Stats Data
+type ClickStats struct {
+	ShortURL  ShortURL        `json:"short"`
+	Count     int             `json:"count"`
+	Interval  string          `json:"interval"`
+	Since     time.Time       `json:"since"`
+	Series    []ClickBucket   `json:"series"`
+	Referrers []ReferrerCount `json:"referrers"`
+}
+
+type ClickBucket struct {
+	Time  time.Time `json:"time"`
+	Count int       `json:"count"`
+}
+
+type ReferrerCount struct {
+	Referrer string `json:"referrer"`
+	Count    int    `json:"count"`
+}
+
+// default range of each stats interval
+var clickIntervals = map[string]time.Duration{
+	"hour": 48 * time.Hour,
+	"day":  30 * 24 * time.Hour,
+	"week": 12 * 7 * 24 * time.Hour,
+}
+
+var ErrInvalidInterval = errors.New("invalid interval, only support hour, day and week")
+
+// number of top referrers returned in stats
+const TOP_REFERRERS = 10
+
+// Start time (UTC) of the interval bucket which the given time belongs to
+func clickBucketTime(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	switch interval {
+	case "hour":
+		return t.Truncate(time.Hour)
+	case "week":
+		// weeks start on monday
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// Hash client ip so that raw addresses are never stored. The secret key keeps
+// the few billion ipv4 addresses from being tried against the hashes.
+func HashIP(ip string) string {
+	mac := hmac.New(sha256.New, []byte(IP_HASH_SALT))
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Use the configured ip hash key, or the one stored in the database which is
+// generated on first use, so that hashes stay comparable across restarts
+func PrepareIPHashSalt(store Store) error {
+	if IP_HASH_SALT != "" {
+		return nil
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	salt, err := store.EnsureSetting("ip_hash_salt", hex.EncodeToString(buf))
+	if err != nil {
+		return err
+	}
+	IP_HASH_SALT = salt
+	return nil
+}
+
+// Record a click event of the short url
+func (urlData *URLData) RecordClick(store Store, referrer, userAgent, ip string) error {
+	return store.RecordClick(ClickEvent{
+		ShortURL:  urlData.ShortURL,
+		ClickedAt: time.Now().UTC().Truncate(time.Second),
+		Referrer:  referrer,
+		UserAgent: userAgent,
+		IPHash:    HashIP(ip),
+		TargetURL: urlData.TargetURL,
+	})
+}
+
+// Get time-bucketed click series and top referrers of the short url
+func (shortURL ShortURL) GetClickStats(store Store, interval string, since time.Time) (*ClickStats, error) {
+	if interval == "" {
+		interval = "day"
+	}
+	defaultRange, ok := clickIntervals[interval]
+	if !ok {
+		return nil, ErrInvalidInterval
+	}
+	if since.IsZero() {
+		since = time.Now().Add(-defaultRange)
+	}
+	since = since.UTC().Truncate(time.Second)
+
+	series, err := store.ClickSeries(shortURL, interval, since)
+	if err != nil {
+		return nil, err
+	}
+	referrers, err := store.TopReferrers(shortURL, since, TOP_REFERRERS)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &ClickStats{
+		ShortURL:  shortURL,
+		Interval:  interval,
+		Since:     since,
+		Series:    series,
+		Referrers: referrers,
+	}
+	for _, bucket := range series {
+		stats.Count += bucket.Count
+	}
+	return stats, nil
+}
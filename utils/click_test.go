@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClickBucketTime(t *testing.T) {
+	// a wednesday in a +02:00 zone
+	clickedAt := time.Date(2024, 5, 15, 1, 30, 0, 0, time.FixedZone("", 2*60*60))
+	tests := map[string]time.Time{
+		"hour": time.Date(2024, 5, 14, 23, 0, 0, 0, time.UTC),
+		"day":  time.Date(2024, 5, 14, 0, 0, 0, 0, time.UTC),
+		"week": time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC),
+	}
+	for interval, want := range tests {
+		if got := clickBucketTime(clickedAt, interval); !got.Equal(want) {
+			t.Errorf("Bucket of %s is not correct: %v", interval, got)
+		}
+	}
+	// sundays belong to the week of the monday before
+	sunday := time.Date(2024, 5, 19, 12, 0, 0, 0, time.UTC)
+	if got := clickBucketTime(sunday, "week"); !got.Equal(tests["week"]) {
+		t.Errorf("Bucket of sunday is not correct: %v", got)
+	}
+}
+
+func TestHashIP(t *testing.T) {
+	defer func(salt string) { IP_HASH_SALT = salt }(IP_HASH_SALT)
+
+	IP_HASH_SALT = "salt"
+	hash := HashIP("127.0.0.1")
+	if len(hash) != 64 || hash != HashIP("127.0.0.1") || hash == HashIP("127.0.0.2") {
+		t.Errorf("Ip hash is not correct")
+	}
+	IP_HASH_SALT = "pepper"
+	if HashIP("127.0.0.1") == hash {
+		t.Errorf("Ip hash does not use the salt")
+	}
+
+	// a missing salt is generated once and kept in the store
+	store := NewMemoryStore()
+	IP_HASH_SALT = ""
+	if err := PrepareIPHashSalt(store); err != nil || len(IP_HASH_SALT) != 64 {
+		t.Fatalf("Ip hash salt is not generated: %v", err)
+	}
+	salt := IP_HASH_SALT
+	IP_HASH_SALT = ""
+	if err := PrepareIPHashSalt(store); err != nil || IP_HASH_SALT != salt {
+		t.Errorf("Stored ip hash salt is not used")
+	}
+}
+
+func TestClickStatsSince(t *testing.T) {
+	store := NewMemoryStore()
+
+	data := CreateData{URL: "https://example.com", CustomURL: "custom"}
+	urlData, _ := data.CreateShortURL(store)
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, clickedAt := range []time.Time{now.Add(-72 * time.Hour), now.Add(-time.Hour), now} {
+		store.RecordClick(ClickEvent{ShortURL: "custom", ClickedAt: clickedAt, Referrer: "https://a.com", IPHash: HashIP("127.0.0.1")})
+	}
+
+	// the default range of hours is 48 hours
+	stats, err := urlData.ShortURL.GetClickStats(store, "hour", time.Time{})
+	if err != nil || stats.Count != 2 || stats.Interval != "hour" {
+		t.Errorf("Default range is not correct: %+v %v", stats, err)
+	}
+	stats, err = urlData.ShortURL.GetClickStats(store, "", now.Add(-100*time.Hour))
+	if err != nil || stats.Count != 3 || stats.Interval != "day" || len(stats.Referrers) != 1 || stats.Referrers[0].Count != 3 {
+		t.Errorf("Stats since a time are not correct: %+v %v", stats, err)
+	}
+}
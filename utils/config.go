@@ -0,0 +1,435 @@
+package utils
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/compose-spec/compose-go/dotenv"
+	"github.com/gin-gonic/gin"
+	"github.com/ulule/limiter/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// Server configuration. Every setting is read from these sources, later ones
+// taking precedence over earlier ones:
+//
+//  1. defaults
+//  2. the YAML file given by the -config flag or the CONFIG_FILE env
+//  3. env vars, including those set in a .env file
+//  4. command line flags
+//
+// Each setting is named by its yaml key inside its section, its env var in the
+// env tag and a flag which is the env var in lower case with dashes, e.g.
+// DB_PATH is set with -db-path. Lists are comma separated in env vars and flags,
+// rates are written as <limit>/<period>, e.g. 50/10m.
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Database DatabaseConfig `yaml:"database"`
+	Links    LinksConfig    `yaml:"links"`
+	Clicks   ClicksConfig   `yaml:"clicks"`
+	Fetch    FetchConfig    `yaml:"fetch"`
+	Limits   LimitsConfig   `yaml:"limits"`
+}
+
+type ServerConfig struct {
+	Host            string        `yaml:"host" env:"HOST" help:"listen host, 0.0.0.0 in release mode and 127.0.0.1 otherwise when empty"`
+	Port            int           `yaml:"port" env:"PORT" help:"listen port"`
+	Mode            string        `yaml:"mode" env:"GIN_MODE" help:"gin mode: debug, release or test"`
+	Support         string        `yaml:"support" env:"SUPPORT" help:"support link shown on error pages"`
+	MetricsAddr     string        `yaml:"metrics_addr" env:"METRICS_ADDR" help:"admin listener serving /metrics, e.g. 127.0.0.1:9090, metrics are served on the public listener when empty"`
+	ShutdownDrain   time.Duration `yaml:"shutdown_drain" env:"SHUTDOWN_DRAIN" help:"how long /readyz fails before the server stops on shutdown"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" help:"how long to wait for in-flight requests and background workers on shutdown"`
+}
+
+type DatabaseConfig struct {
+	Path         string `yaml:"path" env:"DB_PATH" help:"sqlite database path"`
+	URL          string `yaml:"url" env:"DATABASE_URL" help:"database url, takes precedence over the path when set"`
+	AutoMigrate  bool   `yaml:"auto_migrate" env:"AUTO_MIGRATE" help:"apply pending schema migrations on startup"`
+	LegacyImport string `yaml:"legacy_import" env:"LEGACY_IMPORT" help:"legacy urls.json to import on startup, renamed with an .imported suffix afterwards"`
+}
+
+type LinksConfig struct {
+	Hostname        string        `yaml:"hostname" env:"SHORT_URL_HOSTNAME" help:"hostname of short links, which links cannot redirect to"`
+	BaseURL         string        `yaml:"base_url" env:"BASE_URL" help:"public base url of short links in qr codes, e.g. https://sho.rt, taken from the request when empty"`
+	IDLength        int           `yaml:"id_length" env:"ID_LENGTH" help:"length of random short urls"`
+	IDAlphabet      string        `yaml:"id_alphabet" env:"ID_ALPHABET" help:"characters of random short urls"`
+	DefaultRedirect int           `yaml:"default_redirect" env:"DEFAULT_REDIRECT" help:"redirect status of links which do not choose one: 301, 302, 307 or 308"`
+	BulkMaxSize     int           `yaml:"bulk_max_size" env:"BULK_MAX_SIZE" help:"max number of urls in a bulk shorten request"`
+	AllowAnonymous  bool          `yaml:"allow_anonymous" env:"ALLOW_ANONYMOUS" help:"allow creating links without an api key"`
+	PurgeInterval   time.Duration `yaml:"purge_interval" env:"PURGE_INTERVAL" help:"how often expired links are purged"`
+	PurgeRetention  time.Duration `yaml:"purge_retention" env:"PURGE_RETENTION" help:"how long an expired link is kept before being purged"`
+}
+
+type ClicksConfig struct {
+	IPHashSalt    string   `yaml:"ip_hash_salt" env:"IP_HASH_SALT" help:"key of the HMAC of client ips, generated and stored in the database when empty"`
+	BotUserAgents []string `yaml:"bot_user_agents" env:"BOT_USER_AGENTS" help:"user agent signatures of link unfurling bots"`
+}
+
+type FetchConfig struct {
+	MaxBytes        int64         `yaml:"max_bytes" env:"FETCH_MAX_BYTES" help:"max bytes read from a fetched page"`
+	PreviewCacheTTL time.Duration `yaml:"preview_cache_ttl" env:"PREVIEW_CACHE_TTL" help:"how long fetched page meta is reused"`
+}
+
+type LimitsConfig struct {
+	Redirect Rate `yaml:"redirect" env:"RATE_REDIRECT" help:"requests per ip of GET /:id"`
+	Get      Rate `yaml:"get" env:"RATE_GET" help:"requests per ip of GET /api/get/:id"`
+	Shorten  Rate `yaml:"shorten" env:"RATE_SHORTEN" help:"requests per ip of POST /api/shorten"`
+	Bulk     Rate `yaml:"bulk" env:"RATE_BULK" help:"requests per ip of POST /api/shorten/bulk"`
+	Preview  Rate `yaml:"preview" env:"RATE_PREVIEW" help:"requests per ip of POST /api/preview"`
+	Password Rate `yaml:"password" env:"RATE_PASSWORD" help:"failed password attempts of a link per ip"`
+}
+
+// Rate limit written as <limit>/<period>, e.g. 50/10m
+type Rate struct {
+	Limit  int64
+	Period time.Duration
+}
+
+func (rate Rate) String() string {
+	return strconv.FormatInt(rate.Limit, 10) + "/" + rate.Period.String()
+}
+
+func (rate Rate) MarshalText() ([]byte, error) {
+	return []byte(rate.String()), nil
+}
+
+func (rate *Rate) UnmarshalText(text []byte) error {
+	limit, period, ok := strings.Cut(string(text), "/")
+	if !ok {
+		return fmt.Errorf("invalid rate %q, expected <limit>/<period>", text)
+	}
+	var err error
+	if rate.Limit, err = strconv.ParseInt(strings.TrimSpace(limit), 10, 64); err != nil {
+		return fmt.Errorf("invalid rate limit %q", limit)
+	}
+	if rate.Period, err = time.ParseDuration(strings.TrimSpace(period)); err != nil {
+		return fmt.Errorf("invalid rate period %q", period)
+	}
+	return nil
+}
+
+func (rate Rate) limiterRate() limiter.Rate {
+	return limiter.Rate{Limit: rate.Limit, Period: rate.Period}
+}
+
+func newRate(rate limiter.Rate) Rate {
+	return Rate{Limit: rate.Limit, Period: rate.Period}
+}
+
+// defaults are the initial values of the package settings
+var defaultConfig = Config{
+	Server: ServerConfig{
+		Port: 8080,
+		// drain and timeout stay within the 10s docker stop gives before SIGKILL
+		ShutdownDrain:   3 * time.Second,
+		ShutdownTimeout: 5 * time.Second,
+	},
+	Database: DatabaseConfig{
+		Path:        "storage/database.db",
+		AutoMigrate: true,
+	},
+	Links: LinksConfig{
+		Hostname:        HOSTNAME,
+		IDLength:        SHORT_ID_LENGTH,
+		IDAlphabet:      SHORT_KEYS,
+		DefaultRedirect: DEFAULT_REDIRECT,
+		BulkMaxSize:     BULK_MAX_SIZE,
+		AllowAnonymous:  ALLOW_ANONYMOUS,
+		PurgeInterval:   PURGE_INTERVAL,
+		PurgeRetention:  PURGE_RETENTION,
+	},
+	Clicks: ClicksConfig{
+		IPHashSalt:    IP_HASH_SALT,
+		BotUserAgents: BOT_USER_AGENTS,
+	},
+	Fetch: FetchConfig{
+		MaxBytes:        FETCH_MAX_BYTES,
+		PreviewCacheTTL: PREVIEW_CACHE_TTL,
+	},
+	Limits: LimitsConfig{
+		Redirect: newRate(REDIRECT_RATE),
+		Get:      newRate(GET_RATE),
+		Shorten:  newRate(SHORTEN_RATE),
+		Bulk:     newRate(BULK_RATE),
+		Preview:  newRate(PREVIEW_RATE),
+		Password: newRate(PASSWORD_RATE),
+	},
+}
+
+// Default configuration
+func DefaultConfig() *Config {
+	cfg := defaultConfig
+	cfg.Clicks.BotUserAgents = append([]string{}, defaultConfig.Clicks.BotUserAgents...)
+	return &cfg
+}
+
+// Load configuration from the config file, env vars and flags in args, returning
+// the arguments left after the flags. All invalid settings are reported at once.
+func LoadConfig(name string, args []string) (*Config, []string, error) {
+	dotenv.Load()
+	cfg := DefaultConfig()
+	settings := cfg.settings()
+	// legacy HOSTNAME is only a default, docker and kubernetes set it to the container name
+	if hostname := os.Getenv("HOSTNAME"); hostname != "" {
+		cfg.Links.Hostname = hostname
+	}
+
+	// flags are parsed first to find the config file, but applied last
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML config file, env CONFIG_FILE")
+	flagValues := map[string]*flagValue{}
+	for _, s := range settings {
+		flagValues[s.flag] = &flagValue{setting: s}
+		flags.Var(flagValues[s.flag], s.flag, s.help+", env "+s.env)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	var errs []error
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, s := range settings {
+		if value := os.Getenv(s.env); value != "" {
+			if err := s.set(value); err != nil {
+				errs = append(errs, fmt.Errorf("env %s: %w", s.env, err))
+			}
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if value, ok := flagValues[f.Name]; ok {
+			if err := value.setting.set(value.raw); err != nil {
+				errs = append(errs, fmt.Errorf("flag -%s: %w", f.Name, err))
+			}
+		}
+	})
+	errs = append(errs, cfg.Validate())
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	return cfg, flags.Args(), nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	// report misspelled keys instead of ignoring them
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate the configuration, returning every invalid setting
+func (cfg *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(cfg.Server.Port > 0 && cfg.Server.Port <= 65535, "server.port must be between 1 and 65535")
+	switch cfg.Server.Mode {
+	case "", gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+	default:
+		check(false, "server.mode must be debug, release or test")
+	}
+	check(cfg.Server.ShutdownDrain >= 0, "server.shutdown_drain must not be negative")
+	check(cfg.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(cfg.Database.Path != "" || cfg.Database.URL != "", "database.path or database.url must be set")
+
+	if cfg.Links.BaseURL != "" {
+		u, err := url.Parse(cfg.Links.BaseURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.RawQuery == "" && u.Fragment == "",
+			"links.base_url must be an http or https url without query")
+	}
+	check(cfg.Links.IDLength >= 4 && cfg.Links.IDLength <= 32, "links.id_length must be between 4 and 32")
+	check(len(cfg.Links.IDAlphabet) >= 2, "links.id_alphabet must have at least 2 characters")
+	seen := map[rune]bool{}
+	for _, c := range cfg.Links.IDAlphabet {
+		if !reCustomURL.MatchString(string(c)) {
+			check(false, "links.id_alphabet must only contain letters, digits, _ and -, found %q", c)
+		} else if seen[c] {
+			check(false, "links.id_alphabet must not repeat characters, found %q twice", c)
+		}
+		seen[c] = true
+	}
+	check(IsValidRedirect(cfg.Links.DefaultRedirect), "links.default_redirect must be 301, 302, 307 or 308")
+	check(cfg.Links.BulkMaxSize > 0, "links.bulk_max_size must be positive")
+	check(cfg.Links.PurgeInterval > 0, "links.purge_interval must be positive")
+	check(cfg.Links.PurgeRetention >= 0, "links.purge_retention must not be negative")
+
+	check(cfg.Fetch.MaxBytes > 0, "fetch.max_bytes must be positive")
+	check(cfg.Fetch.PreviewCacheTTL >= 0, "fetch.preview_cache_ttl must not be negative")
+
+	limits := reflect.ValueOf(cfg.Limits)
+	for i := 0; i < limits.NumField(); i++ {
+		rate := limits.Field(i).Interface().(Rate)
+		check(rate.Limit > 0 && rate.Period > 0, "limits.%s must have a positive limit and period", limits.Type().Field(i).Tag.Get("yaml"))
+	}
+	return errors.Join(errs...)
+}
+
+// Apply the configuration to the package settings, rate limiter counters are reset
+func (cfg *Config) Apply() {
+	HOSTNAME = cfg.Links.Hostname
+	BASE_URL = strings.TrimRight(cfg.Links.BaseURL, "/")
+	SHORT_KEYS, SHORT_LEN, SHORT_ID_LENGTH = cfg.Links.IDAlphabet, len(cfg.Links.IDAlphabet), cfg.Links.IDLength
+	DEFAULT_REDIRECT = cfg.Links.DefaultRedirect
+	BULK_MAX_SIZE = cfg.Links.BulkMaxSize
+	ALLOW_ANONYMOUS = cfg.Links.AllowAnonymous
+	PURGE_INTERVAL, PURGE_RETENTION = cfg.Links.PurgeInterval, cfg.Links.PurgeRetention
+	IP_HASH_SALT = cfg.Clicks.IPHashSalt
+	BOT_USER_AGENTS = cfg.Clicks.BotUserAgents
+	FETCH_MAX_BYTES = cfg.Fetch.MaxBytes
+	PREVIEW_CACHE_TTL = cfg.Fetch.PreviewCacheTTL
+
+	REDIRECT_RATE = cfg.Limits.Redirect.limiterRate()
+	GET_RATE = cfg.Limits.Get.limiterRate()
+	SHORTEN_RATE = cfg.Limits.Shorten.limiterRate()
+	BULK_RATE = cfg.Limits.Bulk.limiterRate()
+	PREVIEW_RATE = cfg.Limits.Preview.limiterRate()
+	PASSWORD_RATE = cfg.Limits.Password.limiterRate()
+	setupLimiters()
+}
+
+// Listen address, the host falls back to all interfaces only in release mode
+func (cfg *ServerConfig) Addr() string {
+	host := cfg.Host
+	if host == "" {
+		host = "127.0.0.1"
+		if cfg.Mode == gin.ReleaseMode {
+			host = "0.0.0.0"
+		}
+	}
+	return host + ":" + strconv.Itoa(cfg.Port)
+}
+
+// Data source of the store, the database url or else the sqlite path
+func (cfg *DatabaseConfig) DSN() string {
+	if cfg.URL != "" {
+		return cfg.URL
+	}
+	return cfg.Path
+}
+
+// a single setting of the config
+type setting struct {
+	env, flag, help string
+	value           reflect.Value
+}
+
+// list settings of every section, pointing into cfg
+func (cfg *Config) settings() []setting {
+	settings := []setting{}
+	sections := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			env := field.Tag.Get("env")
+			settings = append(settings, setting{
+				env:   env,
+				flag:  strings.ReplaceAll(strings.ToLower(env), "_", "-"),
+				help:  field.Tag.Get("help"),
+				value: section.Field(j),
+			})
+		}
+	}
+	return settings
+}
+
+// parse and set the setting from an env var or flag
+func (s setting) set(raw string) error {
+	switch value := s.value.Addr().Interface().(type) {
+	case *string:
+		*value = raw
+	case *[]string:
+		*value = splitList(raw)
+	case *bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		*value = b
+	case *int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		*value = n
+	case *int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		*value = n
+	case *time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		*value = d
+	case encoding.TextUnmarshaler:
+		return value.UnmarshalText([]byte(raw))
+	default:
+		return fmt.Errorf("unsupported setting type %s", s.value.Type())
+	}
+	return nil
+}
+
+// flag holding its raw value until the other sources are loaded
+type flagValue struct {
+	setting setting
+	raw     string
+}
+
+func (f *flagValue) String() string {
+	// flag package calls String on a zero value to print defaults
+	if f == nil || !f.setting.value.IsValid() {
+		return ""
+	}
+	if list, ok := f.setting.value.Interface().([]string); ok {
+		return strings.Join(list, ",")
+	}
+	return fmt.Sprint(f.setting.value.Interface())
+}
+
+func (f *flagValue) Set(raw string) error {
+	f.raw = raw
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.setting.value.IsValid() && f.setting.value.Kind() == reflect.Bool
+}
+
+// split a comma separated list, dropping empty items
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  port: 9000
+  host: 0.0.0.0
+database:
+  path: /data/file.db
+links:
+  id_length: 8
+  id_alphabet: abc123
+limits:
+  shorten: 5/1m
+clicks:
+  bot_user_agents: [MyBot]
+`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("PORT", "9100")
+	t.Setenv("ID_LENGTH", "10")
+
+	cfg, args, err := LoadConfig("test", []string{"-id-length", "12", "-allow-anonymous=false", "migrate", "status"})
+	if err != nil {
+		t.Fatalf("Config is not loaded: %v", err)
+	}
+	if len(args) != 2 || args[0] != "migrate" {
+		t.Errorf("Remaining args are not correct: %q", args)
+	}
+	// file
+	if cfg.Database.Path != "/data/file.db" || cfg.Links.IDAlphabet != "abc123" || cfg.Server.Host != "0.0.0.0" {
+		t.Errorf("File settings are not correct")
+	}
+	if cfg.Limits.Shorten != (Rate{Limit: 5, Period: time.Minute}) || len(cfg.Clicks.BotUserAgents) != 1 {
+		t.Errorf("File settings are not correct")
+	}
+	// env over file
+	if cfg.Server.Port != 9100 || cfg.Server.Addr() != "0.0.0.0:9100" {
+		t.Errorf("Env settings are not correct")
+	}
+	// flags over env
+	if cfg.Links.IDLength != 12 || cfg.Links.AllowAnonymous {
+		t.Errorf("Flag settings are not correct")
+	}
+	// defaults
+	if cfg.Limits.Redirect != newRate(REDIRECT_RATE) || cfg.Links.DefaultRedirect != 307 || cfg.Server.ShutdownDrain != 3*time.Second {
+		t.Errorf("Default settings are not correct")
+	}
+
+	// the container name in HOSTNAME is only a default
+	t.Setenv("HOSTNAME", "3f2a9c1b7d4e")
+	if cfg, _, _ := LoadConfig("test", nil); cfg.Links.Hostname != "3f2a9c1b7d4e" {
+		t.Errorf("Legacy hostname is not used: %q", cfg.Links.Hostname)
+	}
+	hostPath := writeConfigFile(t, "links:\n  hostname: sho.rt\n")
+	if cfg, _, _ := LoadConfig("test", []string{"-config", hostPath}); cfg.Links.Hostname != "sho.rt" {
+		t.Errorf("Legacy hostname overrides the file: %q", cfg.Links.Hostname)
+	}
+	t.Setenv("SHORT_URL_HOSTNAME", "s.test")
+	if cfg, _, _ := LoadConfig("test", []string{"-config", hostPath}); cfg.Links.Hostname != "s.test" {
+		t.Errorf("Hostname env var is not used: %q", cfg.Links.Hostname)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	path := writeConfigFile(t, "links:\n  id_lenght: 8\n")
+	if _, _, err := LoadConfig("test", []string{"-config", path}); err == nil || !strings.Contains(err.Error(), "id_lenght") {
+		t.Errorf("Unknown key is not reported: %v", err)
+	}
+
+	t.Setenv("PORT", "http")
+	t.Setenv("RATE_BULK", "10 per minute")
+	_, _, err := LoadConfig("test", nil)
+	if err == nil || !strings.Contains(err.Error(), "PORT") || !strings.Contains(err.Error(), "RATE_BULK") {
+		t.Errorf("Invalid env vars are not reported: %v", err)
+	}
+
+	t.Setenv("PORT", "")
+	t.Setenv("RATE_BULK", "")
+	_, _, err = LoadConfig("test", []string{"-id-length", "2", "-id-alphabet", "aa/", "-default-redirect", "200", "-base-url", "sho.rt"})
+	for _, key := range []string{"id_length", "id_alphabet", "default_redirect", "base_url"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("Invalid %s is not reported: %v", key, err)
+		}
+	}
+}
+
+func TestConfigApply(t *testing.T) {
+	defer DefaultConfig().Apply()
+
+	cfg := DefaultConfig()
+	cfg.Links.IDLength, cfg.Links.IDAlphabet = 9, "xy"
+	cfg.Links.BaseURL = "https://sho.rt/"
+	cfg.Apply()
+	if BASE_URL != "https://sho.rt" {
+		t.Errorf("Base url %q is not correct", BASE_URL)
+	}
+	id := randomShortURL(SHORT_ID_LENGTH)
+	if len(id) != 9 || strings.Trim(string(id), "xy") != "" {
+		t.Errorf("Random short url %q is not correct", id)
+	}
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+var (
+	// how often the purger runs
+	PURGE_INTERVAL = time.Hour
+	// how long an expired link is kept before being deleted
+	PURGE_RETENTION = 30 * 24 * time.Hour
+)
+
+// Start a background purger, call the returned function to stop it and
+// wait until a running purge finishes or ctx is done
+func StartPurger(store Store) (stop func(ctx context.Context) error) {
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(PURGE_INTERVAL)
+		defer ticker.Stop()
+		for {
+			if n, err := store.PurgeExpired(time.Now().Add(-PURGE_RETENTION)); err == nil && n > 0 {
+				log.Println("Purged expired urls:", n)
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func(ctx context.Context) error {
+		close(done)
+		select {
+		case <-exited:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
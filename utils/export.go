@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// number of urls read from or written to the store at a time
+	EXPORT_BATCH_SIZE = 500
+	// max length of a line in an import
+	IMPORT_MAX_LINE = 1 << 20
+)
+
+// What an import does with a url whose id is already used
+type ConflictPolicy string
+
+const (
+	ConflictSkip      ConflictPolicy = "skip"
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	ConflictFail      ConflictPolicy = "fail"
+)
+
+var ErrConflict = errors.New("short url already exists")
+
+// A url in JSON Lines exports, one per line, holding every column of the urls table
+type URLRecord struct {
+	ID        ShortURL    `json:"id"`
+	Target    LongURL     `json:"target"`
+	Meta      *CustomMeta `json:"meta"`
+	Count     int         `json:"count"`
+	CreatedAt time.Time   `json:"created_at"`
+	CreatedBy string      `json:"created_by,omitempty"`
+	ExpiredAt *time.Time  `json:"expired_at"`
+	Redirect  int         `json:"redirect,omitempty"`
+	Password  string      `json:"password,omitempty"` // bcrypt hash
+}
+
+func NewURLRecord(urlData *URLData) URLRecord {
+	return URLRecord{
+		ID:        urlData.ShortURL,
+		Target:    urlData.TargetURL,
+		Meta:      urlData.Meta,
+		Count:     urlData.Count,
+		CreatedAt: urlData.CreatedAt,
+		CreatedBy: urlData.CreatedBy,
+		ExpiredAt: urlData.ExpiredAt,
+		Redirect:  urlData.Redirect,
+		Password:  urlData.PasswordHash,
+	}
+}
+
+func (record *URLRecord) URLData() *URLData {
+	return &URLData{
+		ShortURL:     record.ID,
+		TargetURL:    record.Target,
+		Meta:         record.Meta,
+		Count:        record.Count,
+		ExpiredAt:    record.ExpiredAt,
+		Redirect:     record.Redirect,
+		PasswordHash: record.Password,
+		CreatedAt:    record.CreatedAt,
+		CreatedBy:    record.CreatedBy,
+	}
+}
+
+// check that the record can be stored as a url, as the api checks created urls
+func (record *URLRecord) Check() error {
+	if err := record.ID.IsValid(); err != nil {
+		return err
+	}
+	if err := record.Target.IsValid(); err != nil {
+		return err
+	}
+	if record.Meta != nil && record.Meta.ImageURL != "" && !record.Meta.ImageURLIsValid() {
+		return errors.New("invalid image url")
+	}
+	if record.Password != "" && !isPasswordHash(record.Password) {
+		return errors.New("invalid password hash")
+	}
+	if record.Count < 0 {
+		return errors.New("count must not be negative")
+	}
+	if record.Redirect != 0 && !IsValidRedirect(record.Redirect) {
+		return errors.New("invalid redirect, only support 301, 302, 307 and 308")
+	}
+	return nil
+}
+
+// Call fn with each url after the given id in id order until it returns false,
+// reading the store a batch at a time
+func EachURLData(store Store, after ShortURL, fn func(urlData *URLData) bool) error {
+	for {
+		batch, err := store.ListURLs(after, EXPORT_BATCH_SIZE)
+		if err != nil {
+			return err
+		}
+		for _, urlData := range batch {
+			if !fn(urlData) {
+				return nil
+			}
+		}
+		if len(batch) < EXPORT_BATCH_SIZE {
+			return nil
+		}
+		after = batch[len(batch)-1].ShortURL
+	}
+}
+
+// Write every url of the store to w as JSON Lines, returning the number of urls
+func ExportURLs(store Store, w io.Writer) (int, error) {
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+
+	n := 0
+	var encodeErr error
+	err := EachURLData(store, "", func(urlData *URLData) bool {
+		if encodeErr = encoder.Encode(NewURLRecord(urlData)); encodeErr != nil {
+			return false
+		}
+		n++
+		return true
+	})
+	if err == nil {
+		err = encodeErr
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	return n, err
+}
+
+// A rejected entry of an import
+type ImportError struct {
+	Line int // 0 if the format has no lines
+	ID   ShortURL
+	Err  error
+}
+
+func (err *ImportError) Error() string {
+	msg := err.Err.Error()
+	if err.ID != "" {
+		msg = string(err.ID) + ": " + msg
+	}
+	if err.Line > 0 {
+		msg = fmt.Sprintf("line %d: %s", err.Line, msg)
+	}
+	return msg
+}
+
+func (err *ImportError) Unwrap() error {
+	return err.Err
+}
+
+type ImportReport struct {
+	Created     int
+	Overwritten int
+	Skipped     int
+	// ids which were already used, whether skipped or overwritten
+	Collisions []ShortURL
+	Rejected   []*ImportError
+}
+
+// Import urls from JSON Lines written by ExportURLs, storing a batch at a time.
+// Owners are kept for restoring a backup, dropOwners clears them when importing
+// into another database, where the same api key ids belong to other keys.
+// Invalid lines are rejected and reported while the import goes on. With
+// ConflictFail the import stops at the first used id, returning an ImportError
+// wrapping ErrConflict, and the lines before it stay imported.
+func ImportURLs(store Store, r io.Reader, policy ConflictPolicy, dropOwners bool) (*ImportReport, error) {
+	im, err := newImporter(store, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), IMPORT_MAX_LINE)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		record := URLRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			im.reject(&ImportError{Line: line, Err: errors.New("invalid JSON")})
+			continue
+		}
+		if dropOwners {
+			record.CreatedBy = ""
+		}
+		if err := im.add(line, record); err != nil {
+			return im.report, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return im.report, err
+	}
+	return im.report, im.flush()
+}
+
+// stores checked records a batch at a time
+type importer struct {
+	store  Store
+	policy ConflictPolicy
+	report *ImportReport
+	batch  []*URLData
+	// ids in the batch, which the store does not know yet
+	pending map[ShortURL]bool
+}
+
+func newImporter(store Store, policy ConflictPolicy) (*importer, error) {
+	switch policy {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+	default:
+		return nil, fmt.Errorf("invalid conflict policy %q, only support skip, overwrite and fail", policy)
+	}
+	return &importer{store: store, policy: policy, report: &ImportReport{}, pending: map[ShortURL]bool{}}, nil
+}
+
+func (im *importer) reject(err *ImportError) {
+	im.report.Rejected = append(im.report.Rejected, err)
+}
+
+// check and queue a record read at the given line, only store errors and
+// conflicts with ConflictFail are returned
+func (im *importer) add(line int, record URLRecord) error {
+	if err := record.Check(); err != nil {
+		im.reject(&ImportError{Line: line, ID: record.ID, Err: err})
+		return nil
+	}
+
+	if im.policy == ConflictFail {
+		old, err := im.store.GetURL(record.ID)
+		if err != nil {
+			return err
+		}
+		if old != nil || im.pending[record.ID] {
+			if err := im.flush(); err != nil {
+				return err
+			}
+			return &ImportError{Line: line, ID: record.ID, Err: ErrConflict}
+		}
+	}
+
+	urlData := record.URLData()
+	if urlData.CreatedAt.IsZero() {
+		urlData.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	}
+	if urlData.ExpiredAt != nil {
+		t := urlData.ExpiredAt.UTC()
+		urlData.ExpiredAt = &t
+	}
+	im.batch, im.pending[record.ID] = append(im.batch, urlData), true
+	if len(im.batch) >= EXPORT_BATCH_SIZE {
+		return im.flush()
+	}
+	return nil
+}
+
+func (im *importer) flush() error {
+	if len(im.batch) == 0 {
+		return nil
+	}
+	existed, err := im.store.PutURLs(im.batch, im.policy == ConflictOverwrite)
+	if err != nil {
+		return err
+	}
+	for i, e := range existed {
+		switch {
+		case !e:
+			im.report.Created++
+			continue
+		case im.policy == ConflictOverwrite:
+			im.report.Overwritten++
+		default:
+			im.report.Skipped++
+		}
+		im.report.Collisions = append(im.report.Collisions, im.batch[i].ShortURL)
+	}
+	im.batch, im.pending = im.batch[:0], map[ShortURL]bool{}
+	return nil
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImportURLs(t *testing.T) {
+	createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	hash, _ := hashPassword("secret")
+	source := NewMemoryStore()
+	source.PutURLs([]*URLData{
+		{ShortURL: "one", TargetURL: "https://example.com/1", Meta: &CustomMeta{Title: "title"}, Count: 3, CreatedAt: createdAt, CreatedBy: "1"},
+		{ShortURL: "two", TargetURL: "https://example.com/2", Redirect: 301, PasswordHash: hash, CreatedAt: createdAt},
+	}, false)
+
+	exported := &bytes.Buffer{}
+	if n, err := ExportURLs(source, exported); err != nil || n != 2 {
+		t.Fatalf("Urls are not exported: %d %v", n, err)
+	}
+	if lines := strings.Split(strings.TrimSpace(exported.String()), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[0], `{"id":"one"`) {
+		t.Errorf("Exported lines are not correct: %q", lines)
+	}
+
+	target := NewMemoryStore()
+	report, err := ImportURLs(target, bytes.NewReader(exported.Bytes()), ConflictSkip, false)
+	if err != nil || report.Created != 2 || len(report.Rejected) != 0 {
+		t.Fatalf("Urls are not imported: %+v %v", report, err)
+	}
+	if got, _ := target.GetURL("one"); got == nil || got.Count != 3 || got.CreatedBy != "1" || !got.CreatedAt.Equal(createdAt) || got.Meta == nil || got.Meta.Title != "title" {
+		t.Errorf("Imported url is not correct: %+v", got)
+	}
+	if got, _ := target.GetURL("two"); got == nil || got.Redirect != 301 || !got.PasswordMatches("secret") {
+		t.Errorf("Imported url is not correct: %+v", got)
+	}
+
+	// conflicts and invalid lines
+	input := exported.String() + `{"id":"api","target":"https://example.com"}` + "\n" +
+		`{"id":"three","target":"example"}` + "\nnot json\n\n" +
+		`{"id":"four","target":"https://example.com/4"}` + "\n"
+	report, err = ImportURLs(target, strings.NewReader(input), ConflictSkip, false)
+	if err != nil || report.Created != 1 || report.Skipped != 2 || len(report.Rejected) != 3 || report.Rejected[2].Line != 5 {
+		t.Errorf("Import report is not correct: %+v %v", report, err)
+	}
+
+	target.PutURLs([]*URLData{{ShortURL: "one", TargetURL: "https://example.org"}}, true)
+	report, err = ImportURLs(target, bytes.NewReader(exported.Bytes()), ConflictOverwrite, false)
+	if err != nil || report.Overwritten != 2 {
+		t.Errorf("Import report is not correct: %+v %v", report, err)
+	}
+	if got, _ := target.GetURL("one"); got.TargetURL != "https://example.com/1" {
+		t.Errorf("Url is not overwritten")
+	}
+
+	report, err = ImportURLs(NewMemoryStore(), strings.NewReader(input+`{"id":"one","target":"https://example.com"}`), ConflictFail, false)
+	var importErr *ImportError
+	if !errors.Is(err, ErrConflict) || !errors.As(err, &importErr) || importErr.Line != 8 || report.Created != 3 {
+		t.Errorf("Conflict does not fail the import: %+v %v", report, err)
+	}
+
+	// owners are only dropped on request
+	other := NewMemoryStore()
+	report, err = ImportURLs(other, bytes.NewReader(exported.Bytes()), ConflictSkip, true)
+	if err != nil || report.Created != 2 {
+		t.Errorf("Urls are not imported without owners: %+v %v", report, err)
+	}
+	if got, _ := other.GetURL("one"); got == nil || got.CreatedBy != "" {
+		t.Errorf("Owner is not dropped: %+v", got)
+	}
+
+	// values which the api rejects
+	crafted := `{"id":"img","target":"https://example.com","meta":{"image":"javascript:alert(1)"}}` + "\n" +
+		`{"id":"pass","target":"https://example.com","password":"secret"}` + "\n"
+	report, err = ImportURLs(target, strings.NewReader(crafted), ConflictSkip, false)
+	if err != nil || report.Created != 0 || len(report.Rejected) != 2 {
+		t.Errorf("Invalid values are not rejected: %+v %v", report, err)
+	}
+
+	if _, err := ImportURLs(target, strings.NewReader(""), "replace", false); err == nil {
+		t.Errorf("Invalid conflict policy is not rejected")
+	}
+}
@@ -0,0 +1,270 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Columns of another shortener's export, each field takes the first column found.
+// Column names are matched in lower case without spaces, dashes and underscores,
+// nested JSON fields are joined, e.g. visitsSummary.total is visitssummarytotal.
+type externalFormat struct {
+	// a short code, or a short link whose last path segment is the code
+	codes   []string
+	targets []string
+	titles  []string
+	created []string
+	clicks  []string
+	expires []string
+}
+
+// Supported exports of other shorteners by name
+var EXTERNAL_FORMATS = map[string]externalFormat{
+	// CSV export of the dashboard or JSON of the bitlinks api,
+	// a custom back-half is preferred over the generated one
+	"bitly": {
+		codes:   []string{"custombitlinks", "custombitlink", "link", "bitlink", "shortlink", "shorturl", "id"},
+		targets: []string{"longurl", "destinationurl", "destination", "url"},
+		titles:  []string{"title"},
+		created: []string{"createdat", "datecreated", "created", "creationdate"},
+		clicks:  []string{"totalclicks", "clicks", "userclicks"},
+	},
+	// CSV of the yourls_url table or JSON of the stats api
+	"yourls": {
+		codes:   []string{"keyword", "shorturl"},
+		targets: []string{"url", "longurl"},
+		titles:  []string{"title"},
+		created: []string{"timestamp", "date"},
+		clicks:  []string{"clicks"},
+	},
+	// CSV export of the web client or JSON of the short-urls api
+	"shlink": {
+		codes:   []string{"shortcode", "shorturl"},
+		targets: []string{"longurl"},
+		titles:  []string{"title"},
+		created: []string{"datecreated", "createdat"},
+		clicks:  []string{"visitssummarytotal", "visitscount", "visits"},
+		expires: []string{"metavaliduntil", "validuntil"},
+	},
+}
+
+// layouts of dates found in exports, dates without a zone are UTC
+var externalTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// Import urls from a CSV or JSON export of another shortener, see EXTERNAL_FORMATS.
+// Original short codes are kept, rows whose code is not a valid short url here are
+// rejected like other invalid rows. Titles become meta titles and click totals counts.
+func ImportExternalURLs(store Store, r io.Reader, format string, policy ConflictPolicy) (*ImportReport, error) {
+	columns, ok := EXTERNAL_FORMATS[format]
+	if !ok {
+		return nil, fmt.Errorf("invalid format %q, only support bitly, yourls and shlink", format)
+	}
+	im, err := newImporter(store, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON starts with an object or array, anything else is CSV
+	reader := bufio.NewReader(r)
+	start, err := firstNonSpace(reader)
+	if err != nil {
+		return nil, err
+	}
+	if start == '{' || start == '[' {
+		var data any
+		if err := json.NewDecoder(reader).Decode(&data); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		for _, item := range jsonLinks(data) {
+			row := map[string]string{}
+			flattenJSON(row, "", item)
+			if err := im.addExternal(0, columns, row); err != nil {
+				return im.report, err
+			}
+		}
+		return im.report, im.flush()
+	}
+
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+	for i := range header {
+		header[i] = normalizeColumn(header[i])
+	}
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return im.report, err
+			}
+			im.reject(&ImportError{Line: parseErr.StartLine, Err: errors.New("invalid CSV row")})
+			continue
+		}
+		line, _ := csvReader.FieldPos(0)
+		row := map[string]string{}
+		for i, value := range record {
+			if i < len(header) {
+				row[header[i]] = value
+			}
+		}
+		if err := im.addExternal(line, columns, row); err != nil {
+			return im.report, err
+		}
+	}
+	return im.report, im.flush()
+}
+
+// queue a row of an external export
+func (im *importer) addExternal(line int, columns externalFormat, row map[string]string) error {
+	record := URLRecord{
+		ID:     ShortURL(externalCode(firstColumn(row, columns.codes))),
+		Target: LongURL(strings.TrimSpace(firstColumn(row, columns.targets))),
+	}
+	if title := strings.Join(strings.Fields(firstColumn(row, columns.titles)), " "); title != "" {
+		record.Meta = &CustomMeta{Title: title}
+	}
+	if clicks := strings.TrimSpace(firstColumn(row, columns.clicks)); clicks != "" {
+		count, err := strconv.Atoi(clicks)
+		if err != nil {
+			im.reject(&ImportError{Line: line, ID: record.ID, Err: fmt.Errorf("invalid click count %q", clicks)})
+			return nil
+		}
+		record.Count = count
+	}
+	if createdAt, ok := parseExternalTime(firstColumn(row, columns.created)); ok {
+		record.CreatedAt = createdAt
+	}
+	if expiredAt, ok := parseExternalTime(firstColumn(row, columns.expires)); ok {
+		record.ExpiredAt = &expiredAt
+	}
+	return im.add(line, record)
+}
+
+// find the list of link objects in an api response
+func jsonLinks(data any) []any {
+	switch data := data.(type) {
+	case []any:
+		return data
+	case map[string]any:
+		// bitly and yourls use links, shlink shortUrls.data
+		for _, key := range []string{"links", "shortUrls", "data"} {
+			if value, ok := data[key]; ok {
+				return jsonLinks(value)
+			}
+		}
+		// yourls keys its links by link_1, link_2...
+		keys := []string{}
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		links := []any{}
+		for _, key := range keys {
+			links = append(links, data[key])
+		}
+		return links
+	}
+	return nil
+}
+
+// flatten a JSON value into row, joining nested keys and array items
+func flattenJSON(row map[string]string, prefix string, value any) {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, v := range value {
+			flattenJSON(row, prefix+normalizeColumn(key), v)
+		}
+	case []any:
+		items := []string{}
+		for _, v := range value {
+			item := map[string]string{}
+			flattenJSON(item, "", v)
+			if s, ok := item[""]; ok {
+				items = append(items, s)
+			}
+		}
+		row[prefix] = strings.Join(items, ",")
+	case string:
+		row[prefix] = value
+	case float64:
+		row[prefix] = strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		row[prefix] = strconv.FormatBool(value)
+	}
+}
+
+func normalizeColumn(name string) string {
+	return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(name)))
+}
+
+func firstColumn(row map[string]string, columns []string) string {
+	for _, column := range columns {
+		if value := strings.TrimSpace(row[column]); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// short code of a code or short link, the first one of a list
+func externalCode(value string) string {
+	if fields := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }); len(fields) > 0 {
+		value = fields[0]
+	}
+	value = strings.TrimRight(value, "/")
+	return value[strings.LastIndex(value, "/")+1:]
+}
+
+func parseExternalTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range externalTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC().Truncate(time.Second), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// skip white space and a BOM, returning the next byte which is left unread
+func firstNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		r, _, err := reader.ReadRune()
+		if err == io.EOF {
+			return 0, errors.New("empty export")
+		} else if err != nil {
+			return 0, err
+		}
+		if r != '\ufeff' && !unicode.IsSpace(r) {
+			return byte(r), reader.UnreadRune()
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportExternalURLs(t *testing.T) {
+	store := NewMemoryStore()
+
+	bitly := "\ufeffTitle,Long URL,Link,Custom Bitlinks,Created,Total Clicks\n" +
+		`"Example,  Inc",https://example.com/a,https://bit.ly/3abcDEF,"https://bit.ly/mine, https://bit.ly/other",2023-05-01T10:00:00+0000,12` + "\n" +
+		",https://example.com/b,bit.ly/abcDEF9,,,0\n" +
+		",example,bit.ly/bad,,,1\n" +
+		",https://example.com/c,bit.ly/clicks,,,many\n"
+	report, err := ImportExternalURLs(store, strings.NewReader(bitly), "bitly", ConflictSkip)
+	if err != nil || report.Created != 2 || len(report.Rejected) != 2 || report.Rejected[0].Line != 4 {
+		t.Fatalf("Bitly export is not imported: %+v %v", report, err)
+	}
+	got, _ := store.GetURL("mine")
+	if got == nil || got.TargetURL != "https://example.com/a" || got.Count != 12 || got.Meta == nil || got.Meta.Title != "Example, Inc" ||
+		!got.CreatedAt.Equal(time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Imported bitly url is not correct: %+v", got)
+	}
+	if got, _ := store.GetURL("abcDEF9"); got == nil || got.Meta != nil {
+		t.Errorf("Imported bitly url is not correct: %+v", got)
+	}
+
+	yourls := "keyword,url,title,timestamp,ip,clicks\n" +
+		"yo,https://example.com/y,Yo,2020-01-02 03:04:05,127.0.0.1,3\n" +
+		"a.b,https://example.com/dot,,2020-01-02 03:04:05,127.0.0.1,0\n" +
+		"mine,https://example.com/m,,,,\n"
+	report, err = ImportExternalURLs(store, strings.NewReader(yourls), "yourls", ConflictSkip)
+	if err != nil || report.Created != 1 || report.Skipped != 1 || len(report.Rejected) != 1 || report.Rejected[0].ID != "a.b" {
+		t.Fatalf("YOURLS export is not imported: %+v %v", report, err)
+	}
+	if got, _ := store.GetURL("yo"); got == nil || got.Count != 3 || !got.CreatedAt.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("Imported yourls url is not correct: %+v", got)
+	}
+
+	// api responses
+	yourlsAPI := `{"links": {"link_2": {"shorturl": "https://sho.rt/api2", "url": "https://example.com/2", "clicks": "1"},
+		"link_1": {"shorturl": "https://sho.rt/api1/", "url": "https://example.com/1", "clicks": "2"}}, "stats": {"total_links": "2"}}`
+	report, err = ImportExternalURLs(store, strings.NewReader(yourlsAPI), "yourls", ConflictSkip)
+	if err != nil || report.Created != 2 {
+		t.Errorf("YOURLS api response is not imported: %+v %v", report, err)
+	}
+	if got, _ := store.GetURL("api1"); got == nil || got.Count != 2 {
+		t.Errorf("Imported yourls url is not correct: %+v", got)
+	}
+
+	shlink := `{"shortUrls": {"data": [{"shortCode": "sh", "shortUrl": "https://s.test/sh", "longUrl": "https://example.com/s",
+		"dateCreated": "2022-03-04T05:06:07+02:00", "visitsSummary": {"total": 7, "nonBots": 5}, "title": "Shlink",
+		"meta": {"validSince": null, "validUntil": "2030-01-01T00:00:00+00:00", "maxVisits": null}, "tags": ["a", "b"]}],
+		"pagination": {"currentPage": 1}}}`
+	report, err = ImportExternalURLs(store, strings.NewReader(shlink), "shlink", ConflictSkip)
+	if err != nil || report.Created != 1 {
+		t.Fatalf("Shlink api response is not imported: %+v %v", report, err)
+	}
+	got, _ = store.GetURL("sh")
+	if got == nil || got.Count != 7 || got.Meta.Title != "Shlink" || got.ExpiredAt == nil || got.ExpiredAt.Year() != 2030 ||
+		!got.CreatedAt.Equal(time.Date(2022, 3, 4, 3, 6, 7, 0, time.UTC)) {
+		t.Errorf("Imported shlink url is not correct: %+v", got)
+	}
+
+	if _, err := ImportExternalURLs(store, strings.NewReader(yourls), "tinyurl", ConflictSkip); err == nil {
+		t.Errorf("Invalid format is not rejected")
+	}
+	if _, err := ImportExternalURLs(store, strings.NewReader(" \n"), "shlink", ConflictSkip); err == nil {
+		t.Errorf("Empty export is not rejected")
+	}
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// max redirects followed when fetching a page
+const FETCH_MAX_REDIRECTS = 5
+
+var (
+	// max bytes read from a fetched page, meta tags live in the head
+	FETCH_MAX_BYTES int64 = 1 << 20
+
+	ErrBlockedAddress = errors.New("destination address is not allowed")
+	ErrNotHTML        = errors.New("destination is not a html page")
+
+	// shared address space (RFC 6598) which is not covered by net.IP.IsPrivate
+	sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+	// http client which only connects to public addresses
+	safeClient = &http.Client{
+		Timeout: time.Second * 10,
+		Transport: &http.Transport{
+			// a proxy would connect on our behalf and skip the address check
+			Proxy: nil,
+			DialContext: (&net.Dialer{
+				Timeout: 5 * time.Second,
+				// check the resolved address right before connecting, so that
+				// redirects and dns rebinding cannot reach internal hosts
+				Control: func(network, address string, c syscall.RawConn) error {
+					host, _, err := net.SplitHostPort(address)
+					if err != nil {
+						return err
+					}
+					if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+						return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+					}
+					return nil
+				},
+			}).DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 5 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= FETCH_MAX_REDIRECTS {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("%w: %s", ErrBlockedAddress, req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+)
+
+// check if ip is a public unicast address
+func IsPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		// "this network" and broadcast
+		if ip[0] == 0 || ip.Equal(net.IPv4bcast) || sharedAddressSpace.Contains(ip) {
+			return false
+		}
+	}
+	return !(ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// Fetch a html page from a public address, the body is limited to FETCH_MAX_BYTES
+// and res.Request.URL is the page url after redirects
+func FetchHTML(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", UA)
+	req.Header.Set("Accept", "text/html")
+
+	res, err := safeClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); mediaType != "text/html" {
+		res.Body.Close()
+		return nil, ErrNotHTML
+	}
+	res.Body = limitedBody{Reader: io.LimitReader(res.Body, FETCH_MAX_BYTES), Closer: res.Body}
+	return res, nil
+}
+
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
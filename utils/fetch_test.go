@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.0.0.1", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"255.255.255.255", false},
+		{"224.0.0.1", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"ff02::1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, test := range tests {
+		if IsPublicIP(net.ParseIP(test.ip)) != test.public {
+			t.Errorf("Public check of %s is not correct", test.ip)
+		}
+	}
+}
+
+func TestFetchHTMLBlocked(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<title>internal</title>"))
+	}))
+	defer server.Close()
+
+	if _, err := FetchHTML(context.Background(), server.URL); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Loopback address is not blocked: %v", err)
+	}
+
+	data := CreateData{URL: LongURL(server.URL), Meta: &CustomMeta{}}
+	var invalid *ValidationError
+	if err := data.InsertMeta(context.Background()); !errors.As(err, &invalid) {
+		t.Errorf("Blocked fetch is not a validation error: %v", err)
+	}
+}
@@ -1,85 +1,300 @@
 package utils
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"io"
-	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
 )
 
 const UA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/115.0.0.0 Safari/537.36"
 
+// Meta data of a html page, each field takes the first source found in this order:
+//
+//	Title:        og:title, twitter:title, JSON-LD headline or name, <title>
+//	Description:  og:description, twitter:description, description, JSON-LD description
+//	Image:        og:image, og:image:url, og:image:secure_url, twitter:image, JSON-LD image
+//	ImageWidth:   og:image:width, only when Image comes from og:image
+//	ImageHeight:  og:image:height, only when Image comes from og:image
+//	ThemeColor:   theme-color
+//	SiteName:     og:site_name, application-name, JSON-LD publisher name
+//	CanonicalURL: <link rel="canonical">, og:url
+//	Favicon:      <link rel="icon">, <link rel="shortcut icon">, <link rel="apple-touch-icon">
+//	Twitter*:     twitter:card, twitter:site, twitter:creator
+//
+// Meta tags are matched by either their property or name attribute. Relative
+// urls are resolved against <base href> and the page url. Only the head is read.
+// Entities are decoded and whitespace is collapsed in title, description and site name.
 type HTMLMeta struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Image       string `json:"image"`
-	ThemeColor  string `json:"themeColor"`
+	Title          string `json:"title"`
+	Description    string `json:"description"`
+	Image          string `json:"image"`
+	ImageWidth     int    `json:"imageWidth,omitempty"`
+	ImageHeight    int    `json:"imageHeight,omitempty"`
+	ThemeColor     string `json:"themeColor"`
+	SiteName       string `json:"siteName,omitempty"`
+	CanonicalURL   string `json:"canonicalUrl,omitempty"`
+	Favicon        string `json:"favicon,omitempty"`
+	TwitterCard    string `json:"twitterCard,omitempty"`
+	TwitterSite    string `json:"twitterSite,omitempty"`
+	TwitterCreator string `json:"twitterCreator,omitempty"`
 }
 
-func ExtractHtmlMeta(resp io.Reader) (data HTMLMeta) {
-	tokenizer, titleFound := html.NewTokenizer(resp), false
+// values found in a html head before precedence is applied
+type htmlHead struct {
+	title  string
+	base   string
+	tags   map[string]string // first content of each meta property or name
+	links  map[string]string // first href of each link rel
+	jsonLD jsonLDMeta
+}
+
+// meta fields of JSON-LD structured data
+type jsonLDMeta struct {
+	title       string
+	description string
+	image       string
+	siteName    string
+}
+
+func ExtractHtmlMeta(resp io.Reader) HTMLMeta {
+	return ExtractHtmlMetaFromPage(resp, nil)
+}
+
+// Extract meta of a page, relative urls are resolved against pageURL if it is not nil
+func ExtractHtmlMetaFromPage(resp io.Reader, pageURL *url.URL) HTMLMeta {
+	head := parseHTMLHead(resp)
+	tags := head.tags
+
+	data := HTMLMeta{
+		Title:          firstNonEmpty(tags["og:title"], tags["twitter:title"], head.jsonLD.title, head.title),
+		Description:    firstNonEmpty(tags["og:description"], tags["twitter:description"], tags["description"], head.jsonLD.description),
+		Image:          firstNonEmpty(tags["og:image"], tags["og:image:url"], tags["og:image:secure_url"], tags["twitter:image"], tags["twitter:image:src"], head.jsonLD.image),
+		ThemeColor:     tags["theme-color"],
+		SiteName:       firstNonEmpty(tags["og:site_name"], tags["application-name"], head.jsonLD.siteName),
+		CanonicalURL:   firstNonEmpty(head.links["canonical"], tags["og:url"]),
+		Favicon:        firstNonEmpty(head.links["icon"], head.links["shortcut icon"], head.links["apple-touch-icon"]),
+		TwitterCard:    tags["twitter:card"],
+		TwitterSite:    tags["twitter:site"],
+		TwitterCreator: tags["twitter:creator"],
+	}
+	for _, text := range []*string{&data.Title, &data.Description, &data.SiteName} {
+		*text = collapseSpace(*text)
+	}
+	// dimensions only describe the og image
+	if data.Image != "" && data.Image == firstNonEmpty(tags["og:image"], tags["og:image:url"], tags["og:image:secure_url"]) {
+		data.ImageWidth, _ = strconv.Atoi(tags["og:image:width"])
+		data.ImageHeight, _ = strconv.Atoi(tags["og:image:height"])
+	}
+
+	// resolve relative urls
+	base := pageURL
+	if head.base != "" {
+		if href, err := url.Parse(head.base); err == nil {
+			if base != nil {
+				href = base.ResolveReference(href)
+			}
+			base = href
+		}
+	}
+	if base != nil {
+		for _, u := range []*string{&data.Image, &data.CanonicalURL, &data.Favicon} {
+			*u = resolveURL(base, *u)
+		}
+	}
+	return data
+}
+
+func parseHTMLHead(resp io.Reader) (head htmlHead) {
+	head.tags, head.links = map[string]string{}, map[string]string{}
+	tokenizer, titleFound, jsonLDFound := html.NewTokenizer(resp), false, false
 	for {
 		switch tokenizer.Next() {
 		case html.StartTagToken, html.SelfClosingTagToken:
 			t := tokenizer.Token()
+			attrs := map[string]string{}
+			for _, attr := range t.Attr {
+				attrs[attr.Key] = attr.Val
+			}
 
 			switch t.Data {
 			case "body":
 				return
 			case "title":
-				titleFound = true
+				titleFound = head.title == ""
+			case "base":
+				if head.base == "" {
+					head.base = strings.TrimSpace(attrs["href"])
+				}
 			case "meta":
-				attrs := map[string]string{}
-
-				for _, attr := range t.Attr {
-					attrs[attr.Key] = attr.Val
+				content, ok := attrs["content"]
+				if !ok {
+					continue
 				}
-
-				if property, ok := attrs["property"]; ok {
-					if content, ok := attrs["content"]; ok {
-						switch property {
-						case "description":
-							data.Description = content
-						case "og:title":
-							data.Title = content
-						case "og:description":
-							data.Description = content
-						case "og:image":
-							data.Image = content
-						case "theme-color":
-							data.ThemeColor = content
-						}
+				for _, key := range []string{attrs["property"], attrs["name"]} {
+					key = strings.ToLower(strings.TrimSpace(key))
+					if _, found := head.tags[key]; key != "" && !found {
+						head.tags[key] = strings.TrimSpace(content)
 					}
 				}
+			case "link":
+				rel := strings.Join(strings.Fields(strings.ToLower(attrs["rel"])), " ")
+				if _, found := head.links[rel]; rel != "" && !found && attrs["href"] != "" {
+					head.links[rel] = strings.TrimSpace(attrs["href"])
+				}
+			case "script":
+				jsonLDFound = strings.EqualFold(strings.TrimSpace(attrs["type"]), "application/ld+json")
 			}
 		case html.TextToken:
 			if titleFound {
-				data.Title = tokenizer.Token().Data
+				head.title = strings.TrimSpace(tokenizer.Token().Data)
 				titleFound = false
+			} else if jsonLDFound {
+				head.jsonLD.merge(parseJSONLD(tokenizer.Token().Data))
 			}
+		case html.EndTagToken:
+			titleFound, jsonLDFound = false, false
 		case html.ErrorToken:
 			return
 		}
 	}
 }
 
-func ExtractHtmlMetaFromString(htmlString string) HTMLMeta {
-	return ExtractHtmlMeta(strings.NewReader(htmlString))
+// parse a JSON-LD script, which may hold an object, an array or a @graph of objects
+func parseJSONLD(script string) (meta jsonLDMeta) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(script), &data); err != nil {
+		return
+	}
+
+	nodes := []interface{}{data}
+	for len(nodes) > 0 {
+		node := nodes[0]
+		nodes = nodes[1:]
+		switch node := node.(type) {
+		case []interface{}:
+			nodes = append(nodes, node...)
+		case map[string]interface{}:
+			if graph, ok := node["@graph"]; ok {
+				nodes = append(nodes, graph)
+			}
+			meta.merge(jsonLDMeta{
+				title:       firstNonEmpty(jsonLDString(node["headline"]), jsonLDString(node["name"])),
+				description: jsonLDString(node["description"]),
+				image:       jsonLDString(node["image"]),
+				siteName:    jsonLDName(node["publisher"]),
+			})
+		}
+	}
+	return
+}
+
+// fill empty fields from other
+func (meta *jsonLDMeta) merge(other jsonLDMeta) {
+	meta.title = firstNonEmpty(meta.title, other.title)
+	meta.description = firstNonEmpty(meta.description, other.description)
+	meta.image = firstNonEmpty(meta.image, other.image)
+	meta.siteName = firstNonEmpty(meta.siteName, other.siteName)
+}
+
+// string of a JSON-LD value, which may be a string, an object with url or an array of them
+func jsonLDString(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case map[string]interface{}:
+		return jsonLDString(value["url"])
+	case []interface{}:
+		for _, v := range value {
+			if s := jsonLDString(v); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// name of a JSON-LD organization or person
+func jsonLDName(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case map[string]interface{}:
+		return jsonLDName(value["name"])
+	case []interface{}:
+		for _, v := range value {
+			if s := jsonLDName(v); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// trim and collapse runs of whitespace into a single space
+func collapseSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
 }
 
-func ExtractHtmlMetaFromURL(url string) (HTMLMeta, error) {
-	client := http.Client{Timeout: time.Second * 10}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// transcode a html page to UTF-8, detecting its charset from the BOM,
+// the Content-Type header and <meta charset> in this order
+func decodeHTML(r io.Reader, contentType string) io.Reader {
+	decoded, err := charset.NewReader(r, contentType)
 	if err != nil {
-		return HTMLMeta{}, err
+		// unsupported charset, tokenize as is
+		return r
 	}
-	res, err := client.Do(req)
+	return decoded
+}
+
+func resolveURL(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	u, err := url.Parse(ref)
 	if err != nil {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func ExtractHtmlMetaFromString(htmlString string) HTMLMeta {
+	return ExtractHtmlMeta(strings.NewReader(htmlString))
+}
+
+// Fetch the page and extract its meta, the fetch stops when ctx is done
+func ExtractHtmlMetaFromURL(ctx context.Context, url string) (HTMLMeta, error) {
+	defer func(start time.Time) { metaFetchDuration.Observe(time.Since(start).Seconds()) }(time.Now())
+
+	res, err := FetchHTML(ctx, url)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBlockedAddress):
+			metaFetchFailures.WithLabelValues("blocked").Inc()
+		case errors.Is(err, ErrNotHTML):
+			metaFetchFailures.WithLabelValues("not_html").Inc()
+		default:
+			metaFetchFailures.WithLabelValues("error").Inc()
+		}
 		return HTMLMeta{}, err
 	}
 
 	defer res.Body.Close()
-	return ExtractHtmlMeta(res.Body), nil
+	return ExtractHtmlMetaFromPage(decodeHTML(res.Body, res.Header.Get("Content-Type")), res.Request.URL), nil
 }
@@ -1,6 +1,20 @@
 package utils
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+)
 
 func TestTitle(t *testing.T) {
 	title := "test"
@@ -38,3 +52,153 @@ func TestImage(t *testing.T) {
 		t.Errorf("Image is not correct")
 	}
 }
+
+func TestExtractHtmlMetaFromPage(t *testing.T) {
+	pageURL, _ := url.Parse("https://example.com/blog/post")
+	tests := []struct {
+		name string
+		html string
+		want HTMLMeta
+	}{
+		{
+			name: "name attributes",
+			html: `<head><meta name="description" content="desc"><meta name="theme-color" content="#fff"></head>`,
+			want: HTMLMeta{Description: "desc", ThemeColor: "#fff"},
+		},
+		{
+			name: "open graph before twitter and title",
+			html: `<head><title>title</title><meta name="twitter:title" content="twitter"><meta property="og:title" content="og"></head>`,
+			want: HTMLMeta{Title: "og"},
+		},
+		{
+			name: "twitter card",
+			html: `<head><title>title</title><meta name="twitter:card" content="summary_large_image"><meta name="twitter:site" content="@site">
+				<meta name="twitter:creator" content="@me"><meta name="twitter:title" content="twitter"><meta name="twitter:description" content="desc">
+				<meta name="twitter:image" content="/card.png"></head>`,
+			want: HTMLMeta{Title: "twitter", Description: "desc", Image: "https://example.com/card.png",
+				TwitterCard: "summary_large_image", TwitterSite: "@site", TwitterCreator: "@me"},
+		},
+		{
+			name: "og image dimensions",
+			html: `<head><meta property="og:image" content="img.png"><meta property="og:image:width" content="1200"><meta property="og:image:height" content="630"></head>`,
+			want: HTMLMeta{Image: "https://example.com/blog/img.png", ImageWidth: 1200, ImageHeight: 630},
+		},
+		{
+			name: "dimensions of another image are ignored",
+			html: `<head><meta name="twitter:image" content="https://cdn.com/a.png"><meta property="og:image:width" content="1200"></head>`,
+			want: HTMLMeta{Image: "https://cdn.com/a.png"},
+		},
+		{
+			name: "site name, canonical and favicon",
+			html: `<head><meta property="og:site_name" content="Example"><meta property="og:url" content="https://example.com/og">
+				<link rel="canonical" href="/canonical"><link rel="apple-touch-icon" href="/apple.png"><link rel="Shortcut Icon" href="/favicon.ico"></head>`,
+			want: HTMLMeta{SiteName: "Example", CanonicalURL: "https://example.com/canonical", Favicon: "https://example.com/favicon.ico"},
+		},
+		{
+			name: "base href",
+			html: `<head><base href="https://cdn.example.com/assets/"><meta property="og:image" content="img.png"><link rel="icon" href="icon.svg"></head>`,
+			want: HTMLMeta{Image: "https://cdn.example.com/assets/img.png", Favicon: "https://cdn.example.com/assets/icon.svg"},
+		},
+		{
+			name: "json-ld",
+			html: `<head><title>title</title><script type="application/ld+json">{"@context": "https://schema.org", "@graph": [
+				{"@type": "Article", "headline": "headline", "description": "desc", "image": [{"url": "/ld.png"}], "publisher": {"name": "Publisher"}}]}</script></head>`,
+			want: HTMLMeta{Title: "headline", Description: "desc", Image: "https://example.com/ld.png", SiteName: "Publisher"},
+		},
+		{
+			name: "meta tags before json-ld",
+			html: `<head><meta name="description" content="meta"><script type="application/ld+json">{"name": "ld", "description": "ld"}</script></head>`,
+			want: HTMLMeta{Title: "ld", Description: "meta"},
+		},
+		{
+			name: "invalid json-ld",
+			html: `<head><title>title</title><script type="application/ld+json">{"name": </script></head>`,
+			want: HTMLMeta{Title: "title"},
+		},
+		{
+			name: "body is not read",
+			html: `<head><title>title</title></head><body><meta property="og:title" content="body"></body>`,
+			want: HTMLMeta{Title: "title"},
+		},
+	}
+	for _, test := range tests {
+		if got := ExtractHtmlMetaFromPage(strings.NewReader(test.html), pageURL); got != test.want {
+			t.Errorf("Meta of %s is not correct: %+v", test.name, got)
+		}
+	}
+}
+
+func TestExtractHtmlMetaCharset(t *testing.T) {
+	encode := func(e encoding.Encoding, s string) string {
+		encoded, _ := e.NewEncoder().String(s)
+		return encoded
+	}
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		title       string
+	}{
+		{"content type", "text/html; charset=Shift_JIS", encode(japanese.ShiftJIS, "<title>日本語のタイトル</title>"), "日本語のタイトル"},
+		{"meta charset", "text/html", encode(traditionalchinese.Big5, `<meta charset="big5"><title>繁體中文標題</title>`), "繁體中文標題"},
+		{"meta http-equiv", "text/html", encode(simplifiedchinese.GBK, `<meta http-equiv="Content-Type" content="text/html; charset=gbk"><title>简体中文标题</title>`), "简体中文标题"},
+		{"bom", "text/html; charset=iso-8859-1", encode(unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), "<title>ÜTF-16</title>"), "ÜTF-16"},
+		{"utf-8", "text/html", "<title>中文</title>", "中文"},
+	}
+	for _, test := range tests {
+		if got := ExtractHtmlMeta(decodeHTML(strings.NewReader(test.body), test.contentType)); got.Title != test.title {
+			t.Errorf("Title of %s is not correct: %q", test.name, got.Title)
+		}
+	}
+}
+
+func TestExtractHtmlMetaText(t *testing.T) {
+	tests := []struct {
+		html  string
+		title string
+	}{
+		{"<title>Tom &amp; Jerry&#39;s &lt;Show&gt;</title>", "Tom & Jerry's <Show>"},
+		{`<meta property="og:title" content="A &amp; B">`, "A & B"},
+		{"<title>\n\t  Multi\n   line \t title  \n</title>", "Multi line title"},
+		{`<meta property="og:title" content="  spaced   out  ">`, "spaced out"},
+	}
+	for _, test := range tests {
+		if got := ExtractHtmlMetaFromString(test.html); got.Title != test.title {
+			t.Errorf("Title of %q is not correct: %q", test.html, got.Title)
+		}
+	}
+}
+
+func TestFetchHTMLLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/json" {
+			w.Header().Set("Content-Type", "application/json")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<head><!--" + strings.Repeat(" ", 4096) + "--><title>late</title></head>"))
+	}))
+	defer server.Close()
+
+	// the loopback test server is blocked by the safe client
+	client, maxBytes := safeClient, FETCH_MAX_BYTES
+	defer func() { safeClient, FETCH_MAX_BYTES = client, maxBytes }()
+	safeClient = server.Client()
+
+	if meta, err := ExtractHtmlMetaFromURL(context.Background(), server.URL); err != nil || meta.Title != "late" {
+		t.Errorf("Page is not fetched: %+v %v", meta, err)
+	}
+	FETCH_MAX_BYTES = 1024
+	if meta, err := ExtractHtmlMetaFromURL(context.Background(), server.URL); err != nil || meta.Title != "" {
+		t.Errorf("Page is not limited: %+v %v", meta, err)
+	}
+	if _, err := ExtractHtmlMetaFromURL(context.Background(), server.URL+"/json"); !errors.Is(err, ErrNotHTML) {
+		t.Errorf("Non html page is not rejected: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ExtractHtmlMetaFromURL(ctx, server.URL); !errors.Is(err, context.Canceled) {
+		t.Errorf("Fetch does not stop with its context: %v", err)
+	}
+}
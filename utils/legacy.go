@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// url data of the legacy DATA_PATH file
+type legacyURLData struct {
+	ShortURL  ShortURL    `json:"short"`
+	TargetURL LongURL     `json:"url"`
+	Meta      *CustomMeta `json:"meta"`
+	Count     int         `json:"count"`
+}
+
+// Import the legacy urls.json which stored urls before the database. It holds
+// the url data of every short url keyed by the short url:
+//
+//	{"<id>": {"short": "<id>", "url": "<target>", "meta": {...}, "count": 3}, ...}
+//
+// The whole file is read before anything is stored, and any other structure,
+// unknown field or key which is not the short url of its data fails the import.
+// Short urls and counts are kept. A used id is a collision which is left as it is
+// and reported, so importing a file again only reports collisions.
+func ImportLegacyURLs(store Store, r io.Reader) (*ImportReport, error) {
+	im, err := newImporter(store, ConflictSkip)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid legacy data, expected an object of url data keyed by short url: %w", err)
+	}
+	if raw == nil {
+		return nil, errors.New("invalid legacy data, expected an object of url data keyed by short url")
+	}
+
+	ids := make([]string, 0, len(raw))
+	for id := range raw {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	records := make([]URLRecord, 0, len(ids))
+	for _, id := range ids {
+		data := legacyURLData{}
+		decoder := json.NewDecoder(bytes.NewReader(raw[id]))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&data); err != nil {
+			return nil, fmt.Errorf("invalid legacy url data of %s: %w", id, err)
+		}
+		if string(data.ShortURL) != id {
+			return nil, fmt.Errorf("invalid legacy url data of %s: short url is %q", id, data.ShortURL)
+		}
+		records = append(records, URLRecord{ID: data.ShortURL, Target: data.TargetURL, Meta: data.Meta, Count: data.Count})
+	}
+
+	for _, record := range records {
+		if err := im.add(0, record); err != nil {
+			return im.report, err
+		}
+	}
+	return im.report, im.flush()
+}
+
+// Import the legacy file at path, see ImportLegacyURLs
+func ImportLegacyFile(store Store, path string) (*ImportReport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ImportLegacyURLs(store, file)
+}
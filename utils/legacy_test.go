@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImportLegacyURLs(t *testing.T) {
+	store := NewMemoryStore()
+	store.CreateURL(&URLData{ShortURL: "used", TargetURL: "https://example.org"}, "")
+
+	legacy := `{
+		"abc": {"short": "abc", "url": "https://example.com/a", "meta": {"title": "title"}, "count": 4},
+		"zero": {"short": "zero", "url": "https://example.com/z", "meta": null, "count": 0},
+		"used": {"short": "used", "url": "https://example.com/u", "count": 1},
+		"bad": {"short": "bad", "url": "example"}
+	}`
+	report, err := ImportLegacyURLs(store, strings.NewReader(legacy))
+	if err != nil {
+		t.Fatalf("Legacy urls are not imported: %v", err)
+	}
+	if report.Created != 2 || len(report.Collisions) != 1 || report.Collisions[0] != "used" || len(report.Rejected) != 1 {
+		t.Errorf("Import report is not correct: %+v", report)
+	}
+	if got, _ := store.GetURL("abc"); got == nil || got.Count != 4 || got.Meta == nil || got.Meta.Title != "title" {
+		t.Errorf("Imported url is not correct: %+v", got)
+	}
+	if got, _ := store.GetURL("used"); got.TargetURL != "https://example.org" {
+		t.Errorf("Collision is overwritten")
+	}
+
+	// anything but the legacy layout fails without storing urls
+	for _, invalid := range []string{
+		`"urls"`,
+		`null`,
+		`[{"short": "arr", "url": "https://example.com"}]`,
+		`{"new": {"short": "new", "url": "https://example.com"}, "bare": "https://example.com"}`,
+		`{"new": {"short": "new", "url": "https://example.com"}, "other": {"short": "else", "url": "https://example.com"}}`,
+		`{"new": {"short": "new", "url": "https://example.com"}, "extra": {"short": "extra", "target": "https://example.com"}}`,
+	} {
+		if _, err := ImportLegacyURLs(store, strings.NewReader(invalid)); err == nil {
+			t.Errorf("Invalid legacy data %s is not rejected", invalid)
+		}
+	}
+	if got, _ := store.GetURL("new"); got != nil {
+		t.Errorf("Invalid legacy data is partly imported")
+	}
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shorten_http_requests_total",
+		Help: "Number of http requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shorten_http_request_duration_seconds",
+		Help:    "Latency of http requests by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	redirects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shorten_redirects_total",
+		Help: "Number of short link visits by result: redirect, not_found, expired, protected or error.",
+	}, []string{"result"})
+	linksCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "shorten_links_created_total",
+		Help: "Number of created short links.",
+	})
+	metaFetchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "shorten_meta_fetch_duration_seconds",
+		Help:    "Latency of fetching page meta, cache hits excluded.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+	})
+	metaFetchFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shorten_meta_fetch_failures_total",
+		Help: "Number of failed page meta fetches by reason: blocked, not_html or error.",
+	}, []string{"reason"})
+	rateLimitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shorten_rate_limit_rejections_total",
+		Help: "Number of requests rejected by rate limiters by route.",
+	}, []string{"route"})
+	dbQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shorten_db_query_duration_seconds",
+		Help:    "Latency of store operations by store and operation.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"store", "operation"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpRequestDuration, redirects, linksCreated,
+		metaFetchDuration, metaFetchFailures, rateLimitRejections, dbQueryDuration,
+	)
+}
+
+// Handler serving metrics in the prometheus text format
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// Middleware recording count and latency of requests
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		// route pattern instead of path, so that ids do not create new series
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Record the result of a short link visit
+func RecordRedirect(result string) {
+	redirects.WithLabelValues(result).Inc()
+}
+
+// record latency of a store operation started at start, used with defer
+func observeQuery(store, operation string, start time.Time) {
+	dbQueryDuration.WithLabelValues(store, operation).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Metrics())
+	router.GET("/:id", func(c *gin.Context) { c.Status(http.StatusTemporaryRedirect) })
+
+	for _, path := range []string{"/a", "/b", "/a/b"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if n := testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/:id", "307")); n != 2 {
+		t.Errorf("Requests of route are not counted: %v", n)
+	}
+	if n := testutil.ToFloat64(httpRequests.WithLabelValues("GET", "unmatched", "404")); n != 1 {
+		t.Errorf("Unmatched requests are not counted: %v", n)
+	}
+}
@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// up-migrations of each sql dialect, named as `<version>_<name>.sql`
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+var ErrSchemaTooNew = errors.New("database schema is newer than this version supports, please upgrade")
+
+// Schema Migration Data
+type Migration struct {
+	Version int
+	Name    string
+	Query   string
+}
+
+// Schema Status Data
+type SchemaStatus struct {
+	Current int
+	Latest  int
+	Pending []Migration
+}
+
+// Store which has a versioned schema
+type Migrator interface {
+	SchemaStatus() (*SchemaStatus, error)
+	// Apply all pending migrations in order
+	Migrate() ([]Migration, error)
+}
+
+// Check that the store is reachable and its schema is up to date
+func CheckReady(store Store) error {
+	if err := store.Ping(); err != nil {
+		return fmt.Errorf("database is unavailable: %w", err)
+	}
+	migrator, ok := store.(Migrator)
+	if !ok {
+		return nil
+	}
+	status, err := migrator.SchemaStatus()
+	if err != nil {
+		return fmt.Errorf("database schema is unavailable: %w", err)
+	}
+	if status.Current != status.Latest {
+		return fmt.Errorf("database schema is at version %d but %d is required", status.Current, status.Latest)
+	}
+	return nil
+}
+
+// Refuse a schema newer than this binary, and apply pending migrations
+// if autoMigrate is set or fail if there are any
+func PrepareSchema(store Store, autoMigrate bool) error {
+	migrator, ok := store.(Migrator)
+	if !ok {
+		return nil
+	}
+	status, err := migrator.SchemaStatus()
+	if err != nil {
+		return err
+	}
+	if status.Current > status.Latest {
+		return ErrSchemaTooNew
+	}
+	if len(status.Pending) == 0 {
+		return nil
+	}
+	if !autoMigrate {
+		return fmt.Errorf("database schema is at version %d but %d is required, run the migrate command first", status.Current, status.Latest)
+	}
+	applied, err := migrator.Migrate()
+	for _, migration := range applied {
+		log.Printf("Applied migration %04d_%s", migration.Version, migration.Name)
+	}
+	return err
+}
+
+// Load embedded migrations of the sql dialect ordered by version
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []Migration{}
+	for _, entry := range entries {
+		version, name, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		if !ok || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		v, err := strconv.Atoi(version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+		query, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: v, Name: name, Query: string(query)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// migrator of sql stores, tracking applied versions in the schema_version table
+type sqlMigrator struct {
+	db      *sql.DB
+	dialect string
+}
+
+func (m sqlMigrator) SchemaStatus() (*SchemaStatus, error) {
+	migrations, err := loadMigrations(m.dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	// read only, a database without the version table is at version 0
+	status := &SchemaStatus{Pending: []Migration{}}
+	exists, err := m.versionTableExists()
+	if err != nil {
+		log.Println("Error checking schema version table:", err)
+		return nil, err
+	}
+	if exists {
+		if err := m.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&status.Current); err != nil {
+			log.Println("Error getting schema version:", err)
+			return nil, err
+		}
+	}
+	for _, migration := range migrations {
+		status.Latest = migration.Version
+		if migration.Version > status.Current {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+	return status, nil
+}
+
+func (m sqlMigrator) versionTableExists() (bool, error) {
+	query := "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'"
+	if m.dialect == "postgres" {
+		query = "SELECT to_regclass('schema_version') IS NOT NULL"
+	}
+	var exists bool
+	err := m.db.QueryRow(query).Scan(&exists)
+	return exists, err
+}
+
+func (m sqlMigrator) Migrate() ([]Migration, error) {
+	_, err := m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		log.Println("Error creating schema version table:", err)
+		return nil, err
+	}
+
+	status, err := m.SchemaStatus()
+	if err != nil {
+		return nil, err
+	}
+	if status.Current > status.Latest {
+		return nil, ErrSchemaTooNew
+	}
+
+	insertVersion := "INSERT INTO schema_version (version, name) VALUES (?, ?)"
+	if m.dialect == "postgres" {
+		insertVersion = "INSERT INTO schema_version (version, name) VALUES ($1, $2)"
+	}
+
+	applied := []Migration{}
+	for _, migration := range status.Pending {
+		// apply each migration in its own transaction
+		tx, err := m.db.Begin()
+		if err != nil {
+			return applied, err
+		}
+		if _, err := tx.Exec(migration.Query); err != nil {
+			tx.Rollback()
+			return applied, fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		if _, err := tx.Exec(insertVersion, migration.Version, migration.Name); err != nil {
+			tx.Rollback()
+			return applied, err
+		}
+		if err := tx.Commit(); err != nil {
+			return applied, err
+		}
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
@@ -0,0 +1 @@
+ALTER TABLE urls ADD COLUMN redirect INTEGER NOT NULL DEFAULT 0;
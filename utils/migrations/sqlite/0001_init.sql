@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS urls (
+	id TEXT PRIMARY KEY,
+	target_url TEXT NOT NULL,
+	meta TEXT,
+	count INTEGER DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	created_by TEXT,
+	ip TEXT,
+	expired_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS clicks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url_id TEXT NOT NULL,
+	clicked_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	referrer TEXT,
+	user_agent TEXT,
+	ip_hash TEXT,
+	target_url TEXT
+);
+
+CREATE INDEX IF NOT EXISTS clicks_url_id ON clicks (url_id, clicked_at);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	admin INTEGER DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	revoked_at DATETIME
+);
@@ -0,0 +1 @@
+ALTER TABLE urls ADD COLUMN password TEXT NOT NULL DEFAULT '';
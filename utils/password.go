@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt ignores bytes after the 72nd
+const PASSWORD_MAX_LEN = 72
+
+// check if password can be used to protect a link
+func checkPassword(password string) error {
+	if len(password) > PASSWORD_MAX_LEN {
+		return errors.New("password must be at most 72 bytes")
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// check if hash is a bcrypt hash which passwords can be compared with
+func isPasswordHash(hash string) bool {
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
+// check if the link requires a password before redirecting
+func (urlData *URLData) IsProtected() bool {
+	return urlData.PasswordHash != ""
+}
+
+// check if password matches the password of the link
+func (urlData *URLData) PasswordMatches(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(urlData.PasswordHash), []byte(password)) == nil
+}
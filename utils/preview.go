@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// how long fetched page meta is reused
+	PREVIEW_CACHE_TTL = 5 * time.Minute
+	// max number of cached pages
+	PREVIEW_CACHE_SIZE = 1000
+
+	previewCache = metaCache{entries: map[string]metaCacheEntry{}}
+)
+
+// API Preview Requests Data
+type PreviewData struct {
+	URL LongURL `json:"url"`
+}
+
+// cache of fetched page meta by url
+type metaCache struct {
+	mu      sync.Mutex
+	entries map[string]metaCacheEntry
+}
+
+type metaCacheEntry struct {
+	meta      HTMLMeta
+	expiredAt time.Time
+}
+
+func (c *metaCache) get(url string) (HTMLMeta, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[url]
+	if !ok || time.Now().After(entry.expiredAt) {
+		return HTMLMeta{}, false
+	}
+	return entry.meta, true
+}
+
+func (c *metaCache) set(url string, meta HTMLMeta) {
+	if PREVIEW_CACHE_TTL == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= PREVIEW_CACHE_SIZE {
+		for key, entry := range c.entries {
+			if now.After(entry.expiredAt) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	// still full: drop any entry
+	for key := range c.entries {
+		if len(c.entries) < PREVIEW_CACHE_SIZE {
+			break
+		}
+		delete(c.entries, key)
+	}
+	c.entries[url] = metaCacheEntry{meta: meta, expiredAt: now.Add(PREVIEW_CACHE_TTL)}
+}
+
+// Fetch meta of a page, reusing results fetched in the last PREVIEW_CACHE_TTL
+func FetchHtmlMeta(ctx context.Context, url string) (HTMLMeta, error) {
+	if meta, ok := previewCache.get(url); ok {
+		return meta, nil
+	}
+	meta, err := ExtractHtmlMetaFromURL(ctx, url)
+	if err != nil {
+		return HTMLMeta{}, err
+	}
+	previewCache.set(url, meta)
+	return meta, nil
+}
+
+// Validate the url and fetch meta of its page
+func (data *PreviewData) Preview(ctx context.Context) (HTMLMeta, error) {
+	data.URL = LongURL(strings.TrimSpace(string(data.URL)))
+	if data.URL == "" {
+		return HTMLMeta{}, &ValidationError{Message: "original URL is required"}
+	}
+	if err := data.URL.IsValid(); err != nil {
+		return HTMLMeta{}, invalidData(err)
+	}
+
+	meta, err := FetchHtmlMeta(ctx, string(data.URL))
+	for _, reason := range []error{ErrBlockedAddress, ErrNotHTML} {
+		if errors.Is(err, reason) {
+			return HTMLMeta{}, &ValidationError{Message: "cannot fetch meta of this url, " + reason.Error()}
+		}
+	}
+	return meta, err
+}
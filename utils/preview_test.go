@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchHtmlMetaCache(t *testing.T) {
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<title>cached</title>"))
+	}))
+	defer server.Close()
+
+	// the loopback test server is blocked by the safe client
+	client := safeClient
+	defer func() { safeClient = client }()
+	safeClient = server.Client()
+
+	for i := 0; i < 2; i++ {
+		if meta, err := FetchHtmlMeta(context.Background(), server.URL); err != nil || meta.Title != "cached" {
+			t.Fatalf("Meta is not fetched: %+v %v", meta, err)
+		}
+	}
+	if hits != 1 {
+		t.Errorf("Meta is fetched %d times", hits)
+	}
+}
+
+func TestPreviewValidation(t *testing.T) {
+	var invalid *ValidationError
+	for _, url := range []LongURL{"", "not a url", "http://127.0.0.1:1/"} {
+		if _, err := (&PreviewData{URL: url}).Preview(context.Background()); !errors.As(err, &invalid) {
+			t.Errorf("Preview of %q is not rejected: %v", url, err)
+		}
+	}
+}
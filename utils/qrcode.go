@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	QR_DEFAULT_SIZE   = 256
+	QR_MIN_SIZE       = 64
+	QR_MAX_SIZE       = 2048
+	QR_DEFAULT_MARGIN = 4
+	QR_MAX_MARGIN     = 16
+)
+
+// error correction levels
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// hex color regex, #rgb or #rrggbb
+var reColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// check if color is a #rgb or #rrggbb hex color
+func IsValidColor(color string) bool {
+	return reColor.MatchString(color)
+}
+
+// QR Code Options
+type QROptions struct {
+	Format     string // png or svg
+	Size       int    // image width and height in pixels
+	Margin     int    // quiet zone in modules
+	Level      string // L, M, Q or H
+	Foreground string // hex color
+	Background string // hex color
+}
+
+// Default QR code options: black on white png
+func DefaultQROptions() QROptions {
+	return QROptions{
+		Format:     "png",
+		Size:       QR_DEFAULT_SIZE,
+		Margin:     QR_DEFAULT_MARGIN,
+		Level:      "M",
+		Foreground: "#000000",
+		Background: "#ffffff",
+	}
+}
+
+// check if options are valid, normalizing level and colors
+func (opts *QROptions) IsValid() error {
+	opts.Format = strings.ToLower(opts.Format)
+	if opts.Format != "png" && opts.Format != "svg" {
+		return errors.New("invalid format, only support png and svg")
+	}
+	if opts.Size < QR_MIN_SIZE || opts.Size > QR_MAX_SIZE {
+		return fmt.Errorf("size must be between %d and %d", QR_MIN_SIZE, QR_MAX_SIZE)
+	}
+	if opts.Margin < 0 || opts.Margin > QR_MAX_MARGIN {
+		return fmt.Errorf("margin must be between 0 and %d", QR_MAX_MARGIN)
+	}
+	opts.Level = strings.ToUpper(opts.Level)
+	if _, ok := qrLevels[opts.Level]; !ok {
+		return errors.New("invalid level, only support L, M, Q and H")
+	}
+	// "#" is optional since it has to be escaped in query strings
+	for _, c := range []*string{&opts.Foreground, &opts.Background} {
+		if !strings.HasPrefix(*c, "#") {
+			*c = "#" + *c
+		}
+		if !IsValidColor(*c) {
+			return errors.New("invalid color, must be #rgb or #rrggbb")
+		}
+	}
+	return nil
+}
+
+// Write QR code of the content in the format of options
+func WriteQRCode(w io.Writer, content string, opts QROptions) error {
+	if err := opts.IsValid(); err != nil {
+		return err
+	}
+	q, err := qrcode.New(content, qrLevels[opts.Level])
+	if err != nil {
+		return err
+	}
+	// margin is drawn by ourselves
+	q.DisableBorder = true
+	bitmap := q.Bitmap()
+
+	if opts.Format == "svg" {
+		return writeQRSVG(w, bitmap, opts)
+	}
+	return writeQRPNG(w, bitmap, opts)
+}
+
+func writeQRPNG(w io.Writer, bitmap [][]bool, opts QROptions) error {
+	modules := len(bitmap) + 2*opts.Margin
+	scale := opts.Size / modules
+	if scale < 1 {
+		scale = 1
+	}
+	size := opts.Size
+	if size < modules {
+		size = modules
+	}
+	// center the code when size is not a multiple of modules
+	offset := (size-scale*modules)/2 + opts.Margin*scale
+
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{
+		parseHexColor(opts.Background),
+		parseHexColor(opts.Foreground),
+	})
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(offset+x*scale+dx, offset+y*scale+dy, 1)
+				}
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+func writeQRSVG(w io.Writer, bitmap [][]bool, opts QROptions) error {
+	modules := len(bitmap) + 2*opts.Margin
+
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x+opts.Margin, y+opts.Margin)
+			}
+		}
+	}
+
+	_, err := fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">
+<rect width="100%%" height="100%%" fill="%s"/>
+<path fill="%s" d="%s"/>
+</svg>
+`, opts.Size, opts.Size, modules, modules, opts.Background, opts.Foreground, path.String())
+	return err
+}
+
+// parse a valid #rgb or #rrggbb color
+func parseHexColor(hex string) color.RGBA {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, _ := strconv.ParseUint(hex, 16, 32)
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}
+}
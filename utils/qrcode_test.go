@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestQRCodeOptions(t *testing.T) {
+	opts := DefaultQROptions()
+	opts.Foreground, opts.Level = "336699", "h"
+	if err := opts.IsValid(); err != nil || opts.Foreground != "#336699" || opts.Level != "H" {
+		t.Errorf("Options are not normalized: %+v %v", opts, err)
+	}
+
+	for _, invalid := range []func(*QROptions){
+		func(o *QROptions) { o.Format = "gif" },
+		func(o *QROptions) { o.Size = QR_MAX_SIZE + 1 },
+		func(o *QROptions) { o.Margin = -1 },
+		func(o *QROptions) { o.Level = "X" },
+		func(o *QROptions) { o.Background = "red" },
+	} {
+		opts := DefaultQROptions()
+		invalid(&opts)
+		if err := opts.IsValid(); err == nil {
+			t.Errorf("Invalid options are not rejected: %+v", opts)
+		}
+	}
+}
+
+func TestWriteQRCode(t *testing.T) {
+	opts := DefaultQROptions()
+	opts.Size = 300
+
+	var buf bytes.Buffer
+	if err := WriteQRCode(&buf, "https://example.com/abc", opts); err != nil {
+		t.Fatalf("Png is not written: %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil || img.Bounds().Dx() != 300 || img.Bounds().Dy() != 300 {
+		t.Errorf("Png size is not correct")
+	}
+
+	buf.Reset()
+	opts.Format = "svg"
+	if err := WriteQRCode(&buf, "https://example.com/abc", opts); err != nil {
+		t.Fatalf("Svg is not written: %v", err)
+	}
+	if !strings.Contains(buf.String(), `width="300"`) {
+		t.Errorf("Svg size is not correct")
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -10,35 +11,63 @@ import (
 )
 
 var (
+	// requests per ip of GET "/:id"
+	REDIRECT_RATE = limiter.Rate{Period: time.Second, Limit: 10}
+	// requests per ip of GET "/api/get/:id"
+	GET_RATE = limiter.Rate{Period: 10 * time.Minute, Limit: 100}
+	// requests per ip of POST "/api/shorten"
+	SHORTEN_RATE = limiter.Rate{Period: 10 * time.Minute, Limit: 50}
+	// requests per ip of POST "/api/shorten/bulk"
+	BULK_RATE = limiter.Rate{Period: 10 * time.Minute, Limit: 10}
+	// requests per ip of POST "/api/preview"
+	PREVIEW_RATE = limiter.Rate{Period: 10 * time.Minute, Limit: 60}
+	// failed password attempts of a link per ip, POST "/:id"
+	PASSWORD_RATE = limiter.Rate{Period: 15 * time.Minute, Limit: 5}
+
 	RedirectLimiter   gin.HandlerFunc
 	GetShortenLimiter gin.HandlerFunc
 	ShortenLimiter    gin.HandlerFunc
+	BulkLimiter       gin.HandlerFunc
+	PreviewLimiter    gin.HandlerFunc
+
+	passwordLimiter *limiter.Limiter
 )
 
 func init() {
-	// GET "/:id"
-	redirectRateLimiter := limiter.New(memory.NewStore(), limiter.Rate{
-		Period: 1 * time.Second,
-		Limit:  10,
-	})
-	RedirectLimiter = mgin.NewMiddleware(redirectRateLimiter, mgin.WithLimitReachedHandler(limitReachedHandler))
-
-	// GET "/api/get/:id"
-	getShortenRateLimiter := limiter.New(memory.NewStore(), limiter.Rate{
-		Period: 10 * time.Minute,
-		Limit:  100,
-	})
-	GetShortenLimiter = mgin.NewMiddleware(getShortenRateLimiter, mgin.WithLimitReachedHandler(limitReachedHandler))
-
-	// POST "/api/shorten"
-	shortenRateLimiter := limiter.New(memory.NewStore(), limiter.Rate{
-		Period: 10 * time.Minute,
-		Limit:  50,
-	})
-	ShortenLimiter = mgin.NewMiddleware(shortenRateLimiter, mgin.WithLimitReachedHandler(limitReachedHandler))
+	setupLimiters()
+}
+
+// (re)create the limiters from the current rates, counters are reset
+func setupLimiters() {
+	RedirectLimiter = newLimiterMiddleware(REDIRECT_RATE)
+	GetShortenLimiter = newLimiterMiddleware(GET_RATE)
+	ShortenLimiter = newLimiterMiddleware(SHORTEN_RATE)
+	BulkLimiter = newLimiterMiddleware(BULK_RATE)
+	PreviewLimiter = newLimiterMiddleware(PREVIEW_RATE)
+	passwordLimiter = limiter.New(memory.NewStore(), PASSWORD_RATE)
+}
+
+func newLimiterMiddleware(rate limiter.Rate) gin.HandlerFunc {
+	return mgin.NewMiddleware(limiter.New(memory.NewStore(), rate), mgin.WithLimitReachedHandler(limitReachedHandler))
+}
+
+// check whether failed password attempts of a link from the ip have reached the limit
+func PasswordLimitReached(c context.Context, id ShortURL, ip string) bool {
+	limit, err := passwordLimiter.Peek(c, string(id)+"|"+ip)
+	if err != nil || limit.Remaining > 0 {
+		return false
+	}
+	rateLimitRejections.WithLabelValues("/:id").Inc()
+	return true
+}
+
+// record a failed password attempt of a link from the ip
+func AddPasswordFailure(c context.Context, id ShortURL, ip string) {
+	passwordLimiter.Get(c, string(id)+"|"+ip)
 }
 
 func limitReachedHandler(c *gin.Context) {
+	rateLimitRejections.WithLabelValues(c.FullPath()).Inc()
 	c.JSON(429, gin.H{"error": "too many requests"})
 	c.Abort()
 }
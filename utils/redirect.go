@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"net/http"
+)
+
+// redirect status used by links which do not choose one
+var DEFAULT_REDIRECT = http.StatusTemporaryRedirect
+
+// check if status is a supported redirect status code
+func IsValidRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// Redirect status code of the link, falls back to the server default
+func (urlData *URLData) RedirectStatus() int {
+	if urlData.Redirect != 0 {
+		return urlData.Redirect
+	}
+	return DEFAULT_REDIRECT
+}
@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// returned by Store.CreateURL when the short url is already used
+var ErrDuplicateID = errors.New("short url already exists")
+
+// Storage backend of short urls, click events and api keys
+type Store interface {
+	// Insert a new short url, return ErrDuplicateID if the id is used
+	CreateURL(urlData *URLData, ip string) error
+	// Insert short urls in a single transaction, returning an error for each of them.
+	// When an id is used, newID is asked for another one to retry with,
+	// the item fails with ErrDuplicateID if it returns false.
+	CreateURLs(urlDatas []*URLData, ip string, newID func(i int) (ShortURL, bool)) ([]error, error)
+	// Insert urls with all their fields, count included, in a single transaction.
+	// A url whose id is used replaces the stored one when overwrite is true and
+	// is left out otherwise, existed reports which ids were used.
+	PutURLs(urlDatas []*URLData, overwrite bool) (existed []bool, err error)
+	// Get url data by id, return nil if not found
+	GetURL(id ShortURL) (*URLData, error)
+	IncreaseCount(id ShortURL) error
+	// Find a url with the same target, meta, expiry and owner, return nil if not found
+	FindSameURL(urlData *URLData) (*URLData, error)
+	// Save target url, meta and expiry of url data
+	UpdateURL(urlData *URLData) error
+	// List urls ordered by id after the given id, use "" to start from the first one
+	ListURLs(after ShortURL, limit int) ([]*URLData, error)
+	// Delete url and its click events
+	DeleteURL(id ShortURL) error
+	// Delete urls which expired before the given time and their click events
+	PurgeExpired(before time.Time) (int64, error)
+
+	RecordClick(event ClickEvent) error
+	// Count clicks since the given time grouped by interval bucket
+	ClickSeries(id ShortURL, interval string, since time.Time) ([]ClickBucket, error)
+	TopReferrers(id ShortURL, since time.Time, limit int) ([]ReferrerCount, error)
+
+	CreateAPIKey(key *APIKey, keyHash string) error
+	// Get an active api key by its hash, return nil if not found or revoked
+	GetAPIKey(keyHash string) (*APIKey, error)
+	ListAPIKeys() ([]APIKey, error)
+	// Revoke an active api key, return false if not found
+	RevokeAPIKey(id int64, at time.Time) (bool, error)
+
+	// Get the value of a setting, storing the given value first if it has none
+	EnsureSetting(name, value string) (string, error)
+
+	// Check that the store is reachable
+	Ping() error
+	Close() error
+}
+
+// Open store by database url, `postgres://` and `postgresql://` urls open a
+// postgresql store, otherwise it is treated as a sqlite file path
+func OpenStore(databaseURL string) (Store, error) {
+	if strings.HasPrefix(databaseURL, "postgres://") || strings.HasPrefix(databaseURL, "postgresql://") {
+		return OpenPostgresStore(databaseURL)
+	}
+	return OpenSQLiteStore(strings.TrimPrefix(databaseURL, "sqlite://"))
+}
+
+// *sql.DB or *sql.Tx
+type sqlExecer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// columns of urls read by scanURLData
+const urlColumns = "id, target_url, meta, count, created_at, created_by, expired_at, redirect, password"
+
+// *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// read url data from a row of urlColumns
+func scanURLData(row rowScanner) (*URLData, error) {
+	var (
+		id         string
+		target_url string
+		meta       sql.NullString
+		count      int
+		created_at sql.NullTime
+		created_by sql.NullString
+		expired_at sql.NullTime
+		redirect   int
+		password   string
+	)
+	if err := row.Scan(&id, &target_url, &meta, &count, &created_at, &created_by, &expired_at, &redirect, &password); err != nil {
+		return nil, err
+	}
+
+	var expiredAt *time.Time
+	if expired_at.Valid {
+		t := expired_at.Time.UTC()
+		expiredAt = &t
+	}
+
+	return &URLData{
+		ShortURL:     ShortURL(id),
+		TargetURL:    LongURL(target_url),
+		Meta:         unmarshalMeta(meta),
+		Count:        count,
+		ExpiredAt:    expiredAt,
+		Redirect:     redirect,
+		PasswordHash: password,
+		CreatedAt:    created_at.Time.UTC(),
+		CreatedBy:    created_by.String,
+	}, nil
+}
+
+// values of url data in the order of urlColumns
+func urlValues(urlData *URLData) ([]any, error) {
+	meta, err := marshalMeta(urlData.Meta)
+	if err != nil {
+		return nil, err
+	}
+	return []any{string(urlData.ShortURL), string(urlData.TargetURL), meta, urlData.Count, urlData.CreatedAt.UTC(),
+		nullString(urlData.CreatedBy), urlData.ExpiredAt, urlData.Redirect, urlData.PasswordHash}, nil
+}
+
+// encode meta into a nullable json string for sql stores
+func marshalMeta(meta *CustomMeta) (sql.NullString, error) {
+	if meta == nil {
+		return sql.NullString{}, nil
+	}
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(metaBytes), Valid: true}, nil
+}
+
+// decode meta from a nullable json string of sql stores
+func unmarshalMeta(meta sql.NullString) *CustomMeta {
+	if !meta.Valid {
+		return nil
+	}
+	customMeta := &CustomMeta{}
+	json.Unmarshal([]byte(meta.String), customMeta)
+	return customMeta
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
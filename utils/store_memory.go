@@ -0,0 +1,321 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// in-memory store, data is lost when the process exits
+type memoryStore struct {
+	mu       sync.RWMutex
+	urls     map[ShortURL]*URLData
+	clicks   []ClickEvent
+	apiKeys  []memoryAPIKey
+	settings map[string]string
+}
+
+type memoryAPIKey struct {
+	APIKey
+	hash string
+}
+
+// Create an empty in-memory store
+func NewMemoryStore() Store {
+	return &memoryStore{urls: map[ShortURL]*URLData{}, settings: map[string]string{}}
+}
+
+// copy url data so that callers cannot modify stored data
+func copyURLData(urlData *URLData) *URLData {
+	copied := *urlData
+	if urlData.Meta != nil {
+		meta := *urlData.Meta
+		copied.Meta = &meta
+	}
+	if urlData.ExpiredAt != nil {
+		expiredAt := *urlData.ExpiredAt
+		copied.ExpiredAt = &expiredAt
+	}
+	return &copied
+}
+
+func (s *memoryStore) Ping() error {
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+func (s *memoryStore) CreateURL(urlData *URLData, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.urls[urlData.ShortURL]; ok {
+		return ErrDuplicateID
+	}
+	s.urls[urlData.ShortURL] = copyURLData(urlData)
+	return nil
+}
+
+func (s *memoryStore) CreateURLs(urlDatas []*URLData, ip string, newID func(i int) (ShortURL, bool)) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := make([]error, len(urlDatas))
+	for i, urlData := range urlDatas {
+		for {
+			if _, ok := s.urls[urlData.ShortURL]; !ok {
+				s.urls[urlData.ShortURL] = copyURLData(urlData)
+				break
+			}
+			id, ok := newID(i)
+			if !ok {
+				errs[i] = ErrDuplicateID
+				break
+			}
+			urlData.ShortURL = id
+		}
+	}
+	return errs, nil
+}
+
+func (s *memoryStore) PutURLs(urlDatas []*URLData, overwrite bool) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existed := make([]bool, len(urlDatas))
+	for i, urlData := range urlDatas {
+		_, existed[i] = s.urls[urlData.ShortURL]
+		if !existed[i] || overwrite {
+			s.urls[urlData.ShortURL] = copyURLData(urlData)
+		}
+	}
+	return existed, nil
+}
+
+func (s *memoryStore) GetURL(id ShortURL) (*URLData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if urlData, ok := s.urls[id]; ok {
+		return copyURLData(urlData), nil
+	}
+	return nil, nil
+}
+
+func (s *memoryStore) IncreaseCount(id ShortURL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if urlData, ok := s.urls[id]; ok {
+		urlData.Count++
+	}
+	return nil
+}
+
+func (s *memoryStore) FindSameURL(urlData *URLData) (*URLData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for id, stored := range s.urls {
+		// protected links are never treated as the same link
+		if stored.TargetURL != urlData.TargetURL || stored.Redirect != urlData.Redirect || stored.CreatedBy != urlData.CreatedBy || stored.IsProtected() {
+			continue
+		}
+		// only links with the same expiry are treated as the same link
+		if (stored.ExpiredAt == nil) != (urlData.ExpiredAt == nil) ||
+			(stored.ExpiredAt != nil && !stored.ExpiredAt.Equal(*urlData.ExpiredAt)) {
+			continue
+		}
+		if (stored.Meta == nil) != (urlData.Meta == nil) ||
+			(stored.Meta != nil && *stored.Meta != *urlData.Meta) {
+			continue
+		}
+		return &URLData{ShortURL: id}, nil
+	}
+	return nil, nil
+}
+
+func (s *memoryStore) UpdateURL(urlData *URLData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.urls[urlData.ShortURL]; ok {
+		updated := copyURLData(urlData)
+		stored.TargetURL, stored.Meta, stored.ExpiredAt = updated.TargetURL, updated.Meta, updated.ExpiredAt
+		stored.Redirect, stored.PasswordHash = updated.Redirect, updated.PasswordHash
+	}
+	return nil
+}
+
+func (s *memoryStore) ListURLs(after ShortURL, limit int) ([]*URLData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := []ShortURL{}
+	for id := range s.urls {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	urlDatas := []*URLData{}
+	for _, id := range ids {
+		urlDatas = append(urlDatas, copyURLData(s.urls[id]))
+	}
+	return urlDatas, nil
+}
+
+func (s *memoryStore) DeleteURL(id ShortURL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.urls, id)
+	s.deleteOrphanClicks()
+	return nil
+}
+
+func (s *memoryStore) PurgeExpired(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for id, urlData := range s.urls {
+		if urlData.ExpiredAt != nil && urlData.ExpiredAt.Before(before) {
+			delete(s.urls, id)
+			n++
+		}
+	}
+	s.deleteOrphanClicks()
+	return n, nil
+}
+
+// remove click events of deleted urls, must hold the write lock
+func (s *memoryStore) deleteOrphanClicks() {
+	clicks := s.clicks[:0]
+	for _, event := range s.clicks {
+		if _, ok := s.urls[event.ShortURL]; ok {
+			clicks = append(clicks, event)
+		}
+	}
+	s.clicks = clicks
+}
+
+func (s *memoryStore) RecordClick(event ClickEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clicks = append(s.clicks, event)
+	return nil
+}
+
+func (s *memoryStore) ClickSeries(id ShortURL, interval string, since time.Time) ([]ClickBucket, error) {
+	if _, ok := clickIntervals[interval]; !ok {
+		return nil, ErrInvalidInterval
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := map[time.Time]int{}
+	for _, event := range s.clicks {
+		if event.ShortURL == id && !event.ClickedAt.Before(since) {
+			counts[clickBucketTime(event.ClickedAt, interval)]++
+		}
+	}
+
+	series := []ClickBucket{}
+	for bucket, count := range counts {
+		series = append(series, ClickBucket{Time: bucket, Count: count})
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Time.Before(series[j].Time) })
+	return series, nil
+}
+
+func (s *memoryStore) TopReferrers(id ShortURL, since time.Time, limit int) ([]ReferrerCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := map[string]int{}
+	for _, event := range s.clicks {
+		if event.ShortURL == id && !event.ClickedAt.Before(since) {
+			counts[event.Referrer]++
+		}
+	}
+
+	referrers := []ReferrerCount{}
+	for referrer, count := range counts {
+		referrers = append(referrers, ReferrerCount{Referrer: referrer, Count: count})
+	}
+	sort.Slice(referrers, func(i, j int) bool {
+		if referrers[i].Count != referrers[j].Count {
+			return referrers[i].Count > referrers[j].Count
+		}
+		return referrers[i].Referrer < referrers[j].Referrer
+	})
+	if len(referrers) > limit {
+		referrers = referrers[:limit]
+	}
+	return referrers, nil
+}
+
+func (s *memoryStore) CreateAPIKey(key *APIKey, keyHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key.ID = int64(len(s.apiKeys) + 1)
+	s.apiKeys = append(s.apiKeys, memoryAPIKey{APIKey: *key, hash: keyHash})
+	return nil
+}
+
+func (s *memoryStore) GetAPIKey(keyHash string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range s.apiKeys {
+		if key.hash == keyHash && key.RevokedAt == nil {
+			found := key.APIKey
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryStore) ListAPIKeys() ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []APIKey{}
+	for _, key := range s.apiKeys {
+		keys = append(keys, key.APIKey)
+	}
+	return keys, nil
+}
+
+func (s *memoryStore) RevokeAPIKey(id int64, at time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.apiKeys {
+		if s.apiKeys[i].ID == id && s.apiKeys[i].RevokedAt == nil {
+			s.apiKeys[i].RevokedAt = &at
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryStore) EnsureSetting(name, value string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.settings[name]; ok {
+		return stored, nil
+	}
+	s.settings[name] = value
+	return value, nil
+}
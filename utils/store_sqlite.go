@@ -0,0 +1,420 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqlite database store
+type sqliteStore struct {
+	db *sql.DB
+	sqlMigrator
+}
+
+// Open (and create if not exists) a sqlite database store
+func OpenSQLiteStore(dbFilePath string) (Store, error) {
+	// check/create database dir
+	dir := filepath.Dir(dbFilePath)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		// dir not exist, create it
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Println("Error creating directory:", dir)
+			return nil, err
+		}
+	}
+	// check/create database file
+	if _, err := os.Stat(dbFilePath); os.IsNotExist(err) {
+		// file not exist, create it
+		file, err := os.Create(dbFilePath)
+		if err != nil {
+			log.Println("Error creating database file:", err)
+			return nil, err
+		}
+		file.Close()
+	}
+	// connect to database
+	db, err := sql.Open("sqlite3", dbFilePath)
+	if err != nil {
+		log.Println("Error opening database:", err)
+		return nil, err
+	}
+
+	return &sqliteStore{db: db, sqlMigrator: sqlMigrator{db: db, dialect: "sqlite"}}, nil
+}
+
+func (s *sqliteStore) Ping() error {
+	return s.db.Ping()
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqliteStore) CreateURL(urlData *URLData, ip string) error {
+	defer observeQuery("sqlite", "create_url", time.Now())
+	return sqliteInsertURL(s.db, urlData, ip)
+}
+
+func (s *sqliteStore) CreateURLs(urlDatas []*URLData, ip string, newID func(i int) (ShortURL, bool)) ([]error, error) {
+	defer observeQuery("sqlite", "create_urls", time.Now())
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	errs := make([]error, len(urlDatas))
+	for i, urlData := range urlDatas {
+		for {
+			errs[i] = sqliteInsertURL(tx, urlData, ip)
+			if !errors.Is(errs[i], ErrDuplicateID) {
+				break
+			}
+			id, ok := newID(i)
+			if !ok {
+				break
+			}
+			urlData.ShortURL = id
+		}
+	}
+	return errs, tx.Commit()
+}
+
+func sqliteInsertURL(exec sqlExecer, urlData *URLData, ip string) error {
+	meta, err := marshalMeta(urlData.Meta)
+	if err != nil {
+		log.Println("Error marshalling meta:", err)
+		return err
+	}
+
+	_, err = exec.Exec("INSERT INTO urls (id, target_url, meta, created_at, created_by, ip, expired_at, redirect, password) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		string(urlData.ShortURL), string(urlData.TargetURL), meta, urlData.CreatedAt.UTC(), nullString(urlData.CreatedBy), ip, urlData.ExpiredAt, urlData.Redirect, urlData.PasswordHash)
+	if err != nil {
+		if sqlErr, ok := err.(sqlite3.Error); ok {
+			// ErrConstraintPrimaryKey
+			if sqlErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+				return ErrDuplicateID
+			}
+		}
+		log.Println("Error inserting url:", err)
+		return err
+	}
+	return nil
+}
+
+func (s *sqliteStore) PutURLs(urlDatas []*URLData, overwrite bool) ([]bool, error) {
+	defer observeQuery("sqlite", "put_urls", time.Now())
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	existed := make([]bool, len(urlDatas))
+	for i, urlData := range urlDatas {
+		values, err := urlValues(urlData)
+		if err != nil {
+			log.Println("Error marshalling meta:", err)
+			return nil, err
+		}
+		result, err := tx.Exec("INSERT INTO urls ("+urlColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING", values...)
+		if err != nil {
+			log.Println("Error putting url:", err)
+			return nil, err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return nil, err
+		} else if n > 0 {
+			continue
+		}
+		existed[i] = true
+		if overwrite {
+			// id goes last for the where clause
+			_, err := tx.Exec("UPDATE urls SET target_url = ?, meta = ?, count = ?, created_at = ?, created_by = ?, expired_at = ?, redirect = ?, password = ? WHERE id = ?",
+				append(values[1:], values[0])...)
+			if err != nil {
+				log.Println("Error putting url:", err)
+				return nil, err
+			}
+		}
+	}
+	return existed, tx.Commit()
+}
+
+func (s *sqliteStore) GetURL(shortURL ShortURL) (*URLData, error) {
+	defer observeQuery("sqlite", "get_url", time.Now())
+	urlData, err := scanURLData(s.db.QueryRow("SELECT "+urlColumns+" FROM urls WHERE id = ?", string(shortURL)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// not found
+			return nil, nil
+		}
+		log.Println("Error getting url data:", err)
+		return nil, err
+	}
+	return urlData, nil
+}
+
+func (s *sqliteStore) ListURLs(after ShortURL, limit int) ([]*URLData, error) {
+	defer observeQuery("sqlite", "list_urls", time.Now())
+	rows, err := s.db.Query("SELECT "+urlColumns+" FROM urls WHERE id > ? ORDER BY id LIMIT ?", string(after), limit)
+	if err != nil {
+		log.Println("Error listing urls:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	urlDatas := []*URLData{}
+	for rows.Next() {
+		urlData, err := scanURLData(rows)
+		if err != nil {
+			log.Println("Error listing urls:", err)
+			return nil, err
+		}
+		urlDatas = append(urlDatas, urlData)
+	}
+	return urlDatas, rows.Err()
+}
+
+func (s *sqliteStore) IncreaseCount(id ShortURL) error {
+	defer observeQuery("sqlite", "increase_count", time.Now())
+	_, err := s.db.Exec("UPDATE urls SET count = count + 1 WHERE id = ?", string(id))
+	return err
+}
+
+func (s *sqliteStore) FindSameURL(urlData *URLData) (*URLData, error) {
+	defer observeQuery("sqlite", "find_same_url", time.Now())
+	createMeta, err := marshalMeta(urlData.Meta)
+	if err != nil {
+		return nil, err
+	}
+
+	// only unprotected links with the same expiry, redirect and owner are treated as the same link
+	rows, err := s.db.Query("SELECT id, meta FROM urls WHERE target_url = ? AND expired_at IS ? AND redirect = ? AND created_by IS ? AND password = ''",
+		string(urlData.TargetURL), urlData.ExpiredAt, urlData.Redirect, nullString(urlData.CreatedBy))
+	if err != nil {
+		log.Println("Error getting url data:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id   string
+			meta sql.NullString
+		)
+		err := rows.Scan(&id, &meta)
+		if err != nil {
+			log.Println("Error found meta:", err)
+			continue
+		}
+		if meta.String == createMeta.String {
+			return &URLData{ShortURL: ShortURL(id)}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *sqliteStore) UpdateURL(urlData *URLData) error {
+	defer observeQuery("sqlite", "update_url", time.Now())
+	meta, err := marshalMeta(urlData.Meta)
+	if err != nil {
+		log.Println("Error marshalling meta:", err)
+		return err
+	}
+
+	_, err = s.db.Exec("UPDATE urls SET target_url = ?, meta = ?, expired_at = ?, redirect = ?, password = ? WHERE id = ?",
+		string(urlData.TargetURL), meta, urlData.ExpiredAt, urlData.Redirect, urlData.PasswordHash, string(urlData.ShortURL))
+	if err != nil {
+		log.Println("Error updating url:", err)
+	}
+	return err
+}
+
+func (s *sqliteStore) DeleteURL(id ShortURL) error {
+	defer observeQuery("sqlite", "delete_url", time.Now())
+	if _, err := s.db.Exec("DELETE FROM urls WHERE id = ?", string(id)); err != nil {
+		log.Println("Error deleting url:", err)
+		return err
+	}
+	if _, err := s.db.Exec("DELETE FROM clicks WHERE url_id = ?", string(id)); err != nil {
+		log.Println("Error deleting click events:", err)
+		return err
+	}
+	return nil
+}
+
+func (s *sqliteStore) PurgeExpired(before time.Time) (int64, error) {
+	defer observeQuery("sqlite", "purge_expired", time.Now())
+	res, err := s.db.Exec("DELETE FROM urls WHERE expired_at IS NOT NULL AND expired_at < ?", before.UTC())
+	if err != nil {
+		log.Println("Error purging expired urls:", err)
+		return 0, err
+	}
+	// remove click events of purged urls
+	if _, err := s.db.Exec("DELETE FROM clicks WHERE url_id NOT IN (SELECT id FROM urls)"); err != nil {
+		log.Println("Error purging click events:", err)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqliteStore) RecordClick(event ClickEvent) error {
+	defer observeQuery("sqlite", "record_click", time.Now())
+	_, err := s.db.Exec("INSERT INTO clicks (url_id, clicked_at, referrer, user_agent, ip_hash, target_url) VALUES (?, ?, ?, ?, ?, ?)",
+		string(event.ShortURL), event.ClickedAt.UTC(), event.Referrer, event.UserAgent, event.IPHash, string(event.TargetURL))
+	if err != nil {
+		log.Println("Error recording click:", err)
+	}
+	return err
+}
+
+// strftime bucket expression of each stats interval
+var sqliteClickBuckets = map[string]string{
+	"hour": `strftime('%Y-%m-%dT%H:00:00Z', clicked_at)`,
+	"day":  `strftime('%Y-%m-%dT00:00:00Z', clicked_at)`,
+	// weeks start on monday
+	"week": `strftime('%Y-%m-%dT00:00:00Z', clicked_at, 'weekday 0', '-6 days')`,
+}
+
+func (s *sqliteStore) ClickSeries(id ShortURL, interval string, since time.Time) ([]ClickBucket, error) {
+	defer observeQuery("sqlite", "click_series", time.Now())
+	bucket, ok := sqliteClickBuckets[interval]
+	if !ok {
+		return nil, ErrInvalidInterval
+	}
+
+	rows, err := s.db.Query("SELECT "+bucket+" AS bucket, COUNT(*) FROM clicks WHERE url_id = ? AND clicked_at >= ? GROUP BY bucket ORDER BY bucket",
+		string(id), since.UTC())
+	if err != nil {
+		log.Println("Error getting click series:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	series := []ClickBucket{}
+	for rows.Next() {
+		var (
+			bucket string
+			count  int
+		)
+		if err := rows.Scan(&bucket, &count); err != nil {
+			log.Println("Error getting click series:", err)
+			return nil, err
+		}
+		bucketTime, err := time.Parse(time.RFC3339, bucket)
+		if err != nil {
+			log.Println("Error parsing click bucket:", err)
+			continue
+		}
+		series = append(series, ClickBucket{Time: bucketTime, Count: count})
+	}
+	return series, rows.Err()
+}
+
+func (s *sqliteStore) TopReferrers(id ShortURL, since time.Time, limit int) ([]ReferrerCount, error) {
+	defer observeQuery("sqlite", "top_referrers", time.Now())
+	rows, err := s.db.Query("SELECT COALESCE(referrer, ''), COUNT(*) AS total FROM clicks WHERE url_id = ? AND clicked_at >= ? GROUP BY 1 ORDER BY total DESC LIMIT ?",
+		string(id), since.UTC(), limit)
+	if err != nil {
+		log.Println("Error getting top referrers:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	referrers := []ReferrerCount{}
+	for rows.Next() {
+		var referrer ReferrerCount
+		if err := rows.Scan(&referrer.Referrer, &referrer.Count); err != nil {
+			log.Println("Error getting top referrers:", err)
+			return nil, err
+		}
+		referrers = append(referrers, referrer)
+	}
+	return referrers, rows.Err()
+}
+
+func (s *sqliteStore) CreateAPIKey(key *APIKey, keyHash string) error {
+	defer observeQuery("sqlite", "create_api_key", time.Now())
+	res, err := s.db.Exec("INSERT INTO api_keys (name, key_hash, admin, created_at) VALUES (?, ?, ?, ?)",
+		key.Name, keyHash, key.Admin, key.CreatedAt.UTC())
+	if err != nil {
+		log.Println("Error creating api key:", err)
+		return err
+	}
+	key.ID, err = res.LastInsertId()
+	return err
+}
+
+func (s *sqliteStore) GetAPIKey(keyHash string) (*APIKey, error) {
+	defer observeQuery("sqlite", "get_api_key", time.Now())
+	key := &APIKey{}
+	err := s.db.QueryRow("SELECT id, name, admin, created_at FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL",
+		keyHash).Scan(&key.ID, &key.Name, &key.Admin, &key.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// not found or revoked
+			return nil, nil
+		}
+		log.Println("Error getting api key:", err)
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *sqliteStore) ListAPIKeys() ([]APIKey, error) {
+	defer observeQuery("sqlite", "list_api_keys", time.Now())
+	rows, err := s.db.Query("SELECT id, name, admin, created_at, revoked_at FROM api_keys ORDER BY id")
+	if err != nil {
+		log.Println("Error listing api keys:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var (
+			key       APIKey
+			revokedAt sql.NullTime
+		)
+		if err := rows.Scan(&key.ID, &key.Name, &key.Admin, &key.CreatedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		if revokedAt.Valid {
+			key.RevokedAt = &revokedAt.Time
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *sqliteStore) RevokeAPIKey(id int64, at time.Time) (bool, error) {
+	defer observeQuery("sqlite", "revoke_api_key", time.Now())
+	res, err := s.db.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", at.UTC(), id)
+	if err != nil {
+		log.Println("Error revoking api key:", err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqliteStore) EnsureSetting(name, value string) (string, error) {
+	defer observeQuery("sqlite", "ensure_setting", time.Now())
+	if _, err := s.db.Exec("INSERT INTO settings (name, value) VALUES (?, ?) ON CONFLICT (name) DO NOTHING", name, value); err != nil {
+		log.Println("Error inserting setting:", err)
+		return "", err
+	}
+	err := s.db.QueryRow("SELECT value FROM settings WHERE name = ?", name).Scan(&value)
+	if err != nil {
+		log.Println("Error getting setting:", err)
+	}
+	return value, err
+}
@@ -1,24 +1,21 @@
 package utils
 
 import (
-	"database/sql"
-	"encoding/json"
+	"context"
 	"errors"
 	"log"
 	"math/rand"
-	"os"
 	"regexp"
-
-	"github.com/compose-spec/compose-go/dotenv"
-	"github.com/mattn/go-sqlite3"
+	"strings"
+	"time"
 )
 
 var HOSTNAME string
 
-const (
-	DATA_PATH  = "urls.json"
-	SHORT_KEYS = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-"
-)
+// public base url of short links, e.g. https://sho.rt, the request host is used when empty
+var BASE_URL string
+
+const DATA_PATH = "urls.json"
 
 type (
 	LongURL  string
@@ -26,21 +23,24 @@ type (
 )
 
 var (
-	SHORT_LEN = len(SHORT_KEYS)
+	// alphabet and length of random short urls
+	SHORT_KEYS      = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-"
+	SHORT_LEN       = len(SHORT_KEYS)
+	SHORT_ID_LENGTH = 6
+	// max number of urls in a bulk shorten request
+	BULK_MAX_SIZE = 500
+	// time given to fetching meta of all urls of a bulk shorten request,
+	// urls whose meta is not fetched by then are created without it
+	BULK_META_TIMEOUT = 30 * time.Second
 
 	// URL validation regex
 	reURL       = regexp.MustCompile(`^(https?://)([\S]+\.)?([^\s/]+\.[^\s/]{2,})(/?[\S]+)?$`)
 	reCustomURL = regexp.MustCompile(`^([\w\-]{1,32})$`)
 
 	// customURL blacklist
-	customURLBlacklist = []string{"api", "dashboard"}
+	customURLBlacklist = []string{"api", "dashboard", "metrics", "healthz", "readyz"}
 )
 
-func init() {
-	dotenv.Load()
-	HOSTNAME = os.Getenv("HOSTNAME")
-}
-
 // Custom Meta Data
 type CustomMeta struct {
 	Title       string `json:"title"`
@@ -55,15 +55,29 @@ func (meta *CustomMeta) ImageURLIsValid() bool {
 
 // Shorten URL Data
 type URLData struct {
-	ShortURL  ShortURL    `json:"short"`
-	TargetURL LongURL     `json:"url"`
-	Meta      *CustomMeta `json:"meta"`
-	Count     int         `json:"count"`
+	ShortURL     ShortURL    `json:"short"`
+	TargetURL    LongURL     `json:"url"`
+	Meta         *CustomMeta `json:"meta"`
+	Count        int         `json:"count"`
+	ExpiredAt    *time.Time  `json:"expiredAt"`
+	Redirect     int         `json:"redirect,omitempty"` // 0 uses the server default
+	PasswordHash string      `json:"-"`                  // bcrypt hash, empty if the link is not protected
+	CreatedAt    time.Time   `json:"createdAt"`
+	CreatedBy    string      `json:"-"`
+}
+
+// check if short url has passed its expiry time
+func (urlData *URLData) IsExpired() bool {
+	return urlData.ExpiredAt != nil && !urlData.ExpiredAt.After(time.Now())
 }
 
-func (urlData *URLData) IncreaseCount() error {
-	_, err := db.Exec("UPDATE urls SET count = count + 1 WHERE id = ?", string(urlData.ShortURL))
-	return err
+func (urlData *URLData) IncreaseCount(store Store) error {
+	return store.IncreaseCount(urlData.ShortURL)
+}
+
+// Save target url, meta and expiry of url data into store
+func (urlData *URLData) Update(store Store) error {
+	return store.UpdateURL(urlData)
 }
 
 // API Requests Data
@@ -71,65 +85,314 @@ type CreateData struct {
 	URL       LongURL     `json:"url"`
 	CustomURL ShortURL    `json:"customUrl"`
 	Meta      *CustomMeta `json:"meta"`
+	ExpiredAt *time.Time  `json:"expiredAt"`
+	TTL       int64       `json:"ttl"`      // seconds
+	Redirect  int         `json:"redirect"` // 301, 302, 307 or 308
+	Password  string      `json:"password"`
+	CreatedBy string      `json:"-"`
+	IP        string      `json:"-"`
 }
 
-// Create a short URL
-func (data *CreateData) CreateShortURL() (*URLData, error) {
-	longURL := data.URL
-	var metaString any = nil
+// Check expiry fields and resolve TTL into an absolute expiry time
+func (data *CreateData) CheckExpiry() error {
+	expiredAt, err := resolveExpiry(data.ExpiredAt, data.TTL)
+	if err != nil {
+		return err
+	}
+	data.ExpiredAt, data.TTL = expiredAt, 0
+	return nil
+}
+
+// resolve expiry time from either an absolute time or a ttl in seconds
+func resolveExpiry(expiredAt *time.Time, ttl int64) (*time.Time, error) {
+	if expiredAt != nil && ttl != 0 {
+		return nil, errors.New("only one of expiredAt and ttl can be set")
+	}
+	if ttl < 0 {
+		return nil, errors.New("ttl must be a positive number of seconds")
+	}
+	if ttl > 0 {
+		t := time.Now().Add(time.Duration(ttl) * time.Second)
+		expiredAt = &t
+	}
+	if expiredAt == nil {
+		return nil, nil
+	}
+	if !expiredAt.After(time.Now()) {
+		return nil, errors.New("expiry time must be in the future")
+	}
+	// store in UTC so that database comparisons stay consistent
+	t := expiredAt.UTC().Truncate(time.Second)
+	return &t, nil
+}
+
+// API Update Requests Data, nil fields are left unchanged
+type UpdateData struct {
+	URL          *LongURL    `json:"url"`
+	Meta         *CustomMeta `json:"meta"`
+	RemoveMeta   bool        `json:"removeMeta"`
+	ExpiredAt    *time.Time  `json:"expiredAt"`
+	TTL          int64       `json:"ttl"` // seconds
+	RemoveExpiry bool        `json:"removeExpiry"`
+	Redirect     *int        `json:"redirect"` // 0 resets to the server default
+	Password     *string     `json:"password"` // empty removes the password
+}
+
+// Validate update data and apply it to url data
+func (data *UpdateData) Apply(urlData *URLData) error {
+	if data.Meta != nil && data.RemoveMeta {
+		return errors.New("only one of meta and removeMeta can be set")
+	}
+	if (data.ExpiredAt != nil || data.TTL != 0) && data.RemoveExpiry {
+		return errors.New("only one of expiry and removeExpiry can be set")
+	}
+
+	updated := *urlData
+	if data.URL != nil {
+		updated.TargetURL = LongURL(strings.TrimSpace(string(*data.URL)))
+		if updated.TargetURL == "" {
+			return errors.New("original URL is required")
+		}
+		if err := updated.TargetURL.IsValid(); err != nil {
+			return err
+		}
+	}
 	if data.Meta != nil {
-		metaBytes, err := json.Marshal(data.Meta)
+		if data.Meta.ImageURL != "" && !data.Meta.ImageURLIsValid() {
+			return errors.New("invalid image url")
+		}
+		updated.Meta = data.Meta
+	} else if data.RemoveMeta {
+		updated.Meta = nil
+	}
+	if data.ExpiredAt != nil || data.TTL != 0 {
+		expiredAt, err := resolveExpiry(data.ExpiredAt, data.TTL)
 		if err != nil {
-			log.Println("Error marshalling meta:", err)
+			return err
+		}
+		updated.ExpiredAt = expiredAt
+	} else if data.RemoveExpiry {
+		updated.ExpiredAt = nil
+	}
+	if data.Redirect != nil {
+		if *data.Redirect != 0 && !IsValidRedirect(*data.Redirect) {
+			return errors.New("invalid redirect, only support 301, 302, 307 and 308")
+		}
+		updated.Redirect = *data.Redirect
+	}
+	if data.Password != nil {
+		if err := checkPassword(*data.Password); err != nil {
+			return err
+		}
+		updated.PasswordHash = ""
+		if *data.Password != "" {
+			hash, err := hashPassword(*data.Password)
+			if err != nil {
+				return err
+			}
+			updated.PasswordHash = hash
+		}
+	}
+
+	*urlData = updated
+	return nil
+}
+
+// error caused by invalid request data, its message is safe to show to clients
+type ValidationError struct {
+	Message string
+}
+
+func (err *ValidationError) Error() string {
+	return err.Message
+}
+
+func invalidData(err error) error {
+	return &ValidationError{Message: err.Error()}
+}
+
+// Validate create data and find an existing short url which is the same.
+// Invalid data returns a ValidationError, other errors come from the store.
+func (data *CreateData) Check(store Store) (*URLData, error) {
+	data.URL = LongURL(strings.TrimSpace(string(data.URL)))
+	// check whether url is empty
+	if data.URL == "" {
+		return nil, &ValidationError{Message: "original URL is required"}
+	}
+	// check whether url format is valid
+	if err := data.URL.IsValid(); err != nil {
+		return nil, invalidData(err)
+	}
+	// check whether expiry is valid
+	if err := data.CheckExpiry(); err != nil {
+		return nil, invalidData(err)
+	}
+	// check whether redirect status is valid
+	if data.Redirect != 0 && !IsValidRedirect(data.Redirect) {
+		return nil, &ValidationError{Message: "invalid redirect, only support 301, 302, 307 and 308"}
+	}
+	// check whether password is valid
+	if err := checkPassword(data.Password); err != nil {
+		return nil, invalidData(err)
+	}
+	// check whether custom url has been used
+	data.CustomURL = ShortURL(strings.TrimSpace(string(data.CustomURL)))
+	if data.CustomURL == "" {
+		// check whether meta data is same
+		if same, err := data.URL.CheckMetaSame(store, *data); same != nil {
+			// data exists and same, return it
+			if urlData, err := same.ShortURL.GetData(store); urlData != nil || err != nil {
+				return urlData, err
+			}
+			return same, nil
+		} else if err != nil {
 			return nil, err
 		}
-		metaString = string(metaBytes)
+	} else if err := data.CustomURL.IsValid(); err != nil {
+		// check whether shortURL format is valid
+		return nil, invalidData(err)
+	} else if old, err := data.CustomURL.GetData(store); old != nil {
+		// check whether shortURL has been used
+		if data.URL != old.TargetURL || data.Meta != old.Meta || old.IsExpired() || old.CreatedBy != data.CreatedBy || old.Redirect != data.Redirect ||
+			old.IsProtected() || data.Password != "" {
+			return nil, &ValidationError{Message: "this custom url is already been used"}
+		}
+		// same as old, return it
+		return old, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if data.Meta != nil {
+		// check whether image url format is valid
+		if data.Meta.ImageURL != "" && !data.Meta.ImageURLIsValid() {
+			return nil, &ValidationError{Message: "invalid image url"}
+		}
 	}
+	return nil, nil
+}
+
+// url data to be created, hashing the password if any
+func (data *CreateData) newURLData() (*URLData, error) {
+	urlData := &URLData{
+		ShortURL:  data.CustomURL,
+		TargetURL: data.URL,
+		Meta:      data.Meta,
+		ExpiredAt: data.ExpiredAt,
+		Redirect:  data.Redirect,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		CreatedBy: data.CreatedBy,
+	}
+	if data.Password != "" {
+		hash, err := hashPassword(data.Password)
+		if err != nil {
+			log.Println("Error hashing password:", err)
+			return nil, err
+		}
+		urlData.PasswordHash = hash
+	}
+	return urlData, nil
+}
 
-	shortURL, err := data.createShortURL(metaString)
+// Create a short URL
+func (data *CreateData) CreateShortURL(store Store) (*URLData, error) {
+	urlData, err := data.newURLData()
 	if err != nil {
 		return nil, err
 	}
 
-	return &URLData{
-		ShortURL:  ShortURL(shortURL),
-		TargetURL: longURL,
-		Meta:      data.Meta,
-	}, nil
+	for {
+		if data.CustomURL == "" {
+			urlData.ShortURL = randomShortURL(SHORT_ID_LENGTH)
+		}
+		err := store.CreateURL(urlData, data.IP)
+		if errors.Is(err, ErrDuplicateID) && data.CustomURL == "" {
+			// retry
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		linksCreated.Inc()
+		return urlData, nil
+	}
 }
 
-// Create a short URL (inner function)
-func (data *CreateData) createShortURL(meta any) (string, error) {
-	shortURL := ""
-	if data.CustomURL == "" {
-		for i := 0; i < 6; i++ {
-			shortURL += string(SHORT_KEYS[rand.Intn(SHORT_LEN)])
+// Check and create a short URL, returning the existing one instead if it is the same.
+// Invalid data returns a ValidationError, created is false when an existing one is returned.
+func (data *CreateData) Shorten(ctx context.Context, store Store) (urlData *URLData, created bool, err error) {
+	// check whether data is valid and already exists
+	if old, err := data.Check(store); err != nil || old != nil {
+		return old, false, err
+	}
+	// if has meta, fill meta field
+	if data.Meta != nil {
+		var invalid *ValidationError
+		if err := data.InsertMeta(ctx); errors.As(err, &invalid) {
+			return nil, false, err
+		}
+	}
+
+	urlData, err = data.CreateShortURL(store)
+	if errors.Is(err, ErrDuplicateID) {
+		// custom url is taken in the meantime
+		return nil, false, &ValidationError{Message: "this custom url is already been used"}
+	} else if err != nil {
+		return nil, false, err
+	}
+	return urlData, true, nil
+}
+
+// Create short URLs of checked create data in a single transaction,
+// returning the url data or an error for each of them
+func CreateShortURLs(store Store, datas []*CreateData, ip string) ([]*URLData, []error, error) {
+	urlDatas := make([]*URLData, len(datas))
+	for i, data := range datas {
+		urlData, err := data.newURLData()
+		if err != nil {
+			return nil, nil, err
+		}
+		if data.CustomURL == "" {
+			urlData.ShortURL = randomShortURL(SHORT_ID_LENGTH)
 		}
-	} else {
-		shortURL = string(data.CustomURL)
+		urlDatas[i] = urlData
 	}
 
-	_, err := db.Exec("INSERT INTO urls (id, target_url, meta) VALUES (?, ?, ?)",
-		shortURL, data.URL, meta)
+	errs, err := store.CreateURLs(urlDatas, ip, func(i int) (ShortURL, bool) {
+		// retry only random urls
+		if datas[i].CustomURL != "" {
+			return "", false
+		}
+		return randomShortURL(SHORT_ID_LENGTH), true
+	})
 	if err != nil {
-		if sqlErr, ok := err.(sqlite3.Error); ok {
-			// ErrConstraintPrimaryKey
-			if sqlErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
-				// retry
-				return data.createShortURL(meta)
-			}
+		return nil, nil, err
+	}
+	for i := range urlDatas {
+		if errs[i] != nil {
+			urlDatas[i] = nil
+		} else {
+			linksCreated.Inc()
 		}
-		log.Println("Error inserting url:", err)
-		return "", err
 	}
+	return urlDatas, errs, nil
+}
 
-	return shortURL, nil
+// generate a random short url
+func randomShortURL(length int) ShortURL {
+	shortURL := ""
+	for i := 0; i < length; i++ {
+		shortURL += string(SHORT_KEYS[rand.Intn(SHORT_LEN)])
+	}
+	return ShortURL(shortURL)
 }
 
-// Insert meta into short url
-func (data *CreateData) InsertMeta() error {
-	htmlMeta, err := ExtractHtmlMetaFromURL(string(data.URL))
-	if err != nil {
+// Insert meta into short url, fields which are already set are kept.
+// Only a blocked destination is a ValidationError, other failures leave meta as it is.
+func (data *CreateData) InsertMeta(ctx context.Context) error {
+	htmlMeta, err := FetchHtmlMeta(ctx, string(data.URL))
+	if errors.Is(err, ErrBlockedAddress) {
+		return &ValidationError{Message: "cannot fetch meta of this url, " + ErrBlockedAddress.Error()}
+	} else if err != nil {
 		log.Println("Error getting meta:", err)
 		return err
 	}
@@ -153,45 +416,14 @@ func (data *CreateData) InsertMeta() error {
 
 // ShortURL functions
 
-// Get url data from database
-func (shortURL ShortURL) GetData() (urlData *URLData, err error) {
-	var (
-		id         string
-		target_url string
-		meta       sql.NullString
-		count      int
-		created_at sql.NullString
-		created_by sql.NullString
-		ip         sql.NullString
-		expired_at sql.NullString
-	)
-	err = db.QueryRow("SELECT * FROM urls WHERE id = ?", string(shortURL)).Scan(
-		&id, &target_url, &meta, &count, &created_at, &created_by, &ip, &expired_at)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			// not found
-			return nil, nil
-		}
-		log.Println("Error getting url data:", err)
-		return nil, err
-	}
-
-	customMeta := &CustomMeta{}
-	if meta.Valid {
-		json.Unmarshal([]byte(meta.String), customMeta)
-	} else {
-		customMeta = nil
-	}
+// Get url data from store
+func (shortURL ShortURL) GetData(store Store) (*URLData, error) {
+	return store.GetURL(shortURL)
+}
 
-	return &URLData{
-		ShortURL:  ShortURL(id),
-		TargetURL: LongURL(target_url),
-		Meta:      customMeta,
-		Count:     count,
-		// CreatedAt: created_at,
-		// CreatedBy: created_by,
-		// ExpiredAt: expired_at,
-	}, nil
+// Delete short url and its click events from store
+func (shortURL ShortURL) Delete(store Store) error {
+	return store.DeleteURL(shortURL)
 }
 
 // check if short url format is valid
@@ -212,42 +444,19 @@ func (shortURL ShortURL) IsValid() error {
 
 // LongURL functions
 
-// Check if long url meta which is in database is same as create data meta
-func (longURL LongURL) CheckMetaSame(data CreateData) (urlData *URLData, err error) {
-	var CreateMeta string
-	if data.Meta == nil {
-		CreateMeta = ""
-	} else {
-		metaBytes, err := json.Marshal(data.Meta)
-		if err != nil {
-			return nil, err
-		}
-		CreateMeta = string(metaBytes)
-	}
-
-	rows, err := db.Query("SELECT id, meta FROM urls WHERE target_url = ?", string(longURL))
-	if err != nil {
-		log.Println("Error getting url data:", err)
-		return nil, err
+// Check if long url meta which is in store is same as create data meta
+func (longURL LongURL) CheckMetaSame(store Store, data CreateData) (*URLData, error) {
+	// protected urls are never shared
+	if data.Password != "" {
+		return nil, nil
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var (
-			id   string
-			meta sql.NullString
-		)
-		err := rows.Scan(&id, &meta)
-		if err != nil {
-			log.Println("Error found meta:", err)
-			continue
-		}
-		if meta.String == CreateMeta {
-			return &URLData{ShortURL: ShortURL(id)}, nil
-		}
-	}
-
-	return nil, nil
+	return store.FindSameURL(&URLData{
+		TargetURL: longURL,
+		Meta:      data.Meta,
+		ExpiredAt: data.ExpiredAt,
+		Redirect:  data.Redirect,
+		CreatedBy: data.CreatedBy,
+	})
 }
 
 // Check if long url format is valid
@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCreateShortURL(t *testing.T) {
+	store := NewMemoryStore()
+
+	data := CreateData{URL: "https://example.com", CustomURL: "custom"}
+	urlData, err := data.CreateShortURL(store)
+	if err != nil || urlData.ShortURL != "custom" {
+		t.Fatalf("Custom url is not created")
+	}
+	if _, err := data.CreateShortURL(store); !errors.Is(err, ErrDuplicateID) {
+		t.Errorf("Duplicate custom url is not rejected")
+	}
+
+	data = CreateData{URL: "https://example.com"}
+	urlData, err = data.CreateShortURL(store)
+	if err != nil || len(urlData.ShortURL) != 6 {
+		t.Fatalf("Random url is not created")
+	}
+	if got, _ := urlData.ShortURL.GetData(store); got == nil || got.TargetURL != data.URL {
+		t.Errorf("Created url is not stored")
+	}
+
+	// theme colors are not validated, only qr colors are
+	data = CreateData{URL: "https://example.com/color", Meta: &CustomMeta{ThemeColor: "red"}}
+	if _, err := data.Check(store); err != nil {
+		t.Errorf("Theme color is rejected: %v", err)
+	}
+}
+
+func TestCreateShortURLs(t *testing.T) {
+	store := NewMemoryStore()
+	store.CreateURL(&URLData{ShortURL: "used", TargetURL: "https://example.com"}, "")
+
+	datas := []*CreateData{
+		{URL: "https://example.com/1"},
+		{URL: "https://example.com/2", CustomURL: "used"},
+		{URL: "https://example.com/3", CustomURL: "custom"},
+	}
+	urlDatas, errs, err := CreateShortURLs(store, datas, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Urls are not created: %v", err)
+	}
+	if errs[0] != nil || len(urlDatas[0].ShortURL) != 6 {
+		t.Errorf("Random url is not created")
+	}
+	if !errors.Is(errs[1], ErrDuplicateID) || urlDatas[1] != nil {
+		t.Errorf("Duplicate custom url is not rejected")
+	}
+	if errs[2] != nil || urlDatas[2].ShortURL != "custom" {
+		t.Errorf("Custom url is not created")
+	}
+	if got, _ := store.GetURL("custom"); got == nil || got.TargetURL != "https://example.com/3" {
+		t.Errorf("Created url is not stored")
+	}
+}
+
+func TestPasswordProtected(t *testing.T) {
+	store := NewMemoryStore()
+
+	data := CreateData{URL: "https://example.com", Password: "secret"}
+	if old, err := data.Check(store); old != nil || err != nil {
+		t.Fatalf("Protected url is not checked: %v", err)
+	}
+	urlData, err := data.CreateShortURL(store)
+	if err != nil || !urlData.IsProtected() || urlData.PasswordHash == "secret" {
+		t.Fatalf("Password is not hashed")
+	}
+	if !urlData.PasswordMatches("secret") || urlData.PasswordMatches("wrong") {
+		t.Errorf("Password is not correct")
+	}
+
+	// protected urls are never shared
+	same := CreateData{URL: "https://example.com", Password: "secret"}
+	if old, _ := same.Check(store); old != nil {
+		t.Errorf("Protected url is shared")
+	}
+	plain := CreateData{URL: "https://example.com"}
+	if old, _ := plain.Check(store); old != nil {
+		t.Errorf("Protected url is shared")
+	}
+
+	empty := ""
+	if err := (&UpdateData{Password: &empty}).Apply(urlData); err != nil || urlData.IsProtected() {
+		t.Errorf("Password is not removed")
+	}
+}
+
+func TestCheckMetaSame(t *testing.T) {
+	store := NewMemoryStore()
+
+	data := CreateData{URL: "https://example.com", Meta: &CustomMeta{Title: "title"}}
+	urlData, _ := data.CreateShortURL(store)
+
+	same := CreateData{URL: "https://example.com", Meta: &CustomMeta{Title: "title"}}
+	if found, _ := same.URL.CheckMetaSame(store, same); found == nil || found.ShortURL != urlData.ShortURL {
+		t.Errorf("Same url is not found")
+	}
+
+	different := CreateData{URL: "https://example.com", Meta: &CustomMeta{Title: "other"}}
+	if found, _ := different.URL.CheckMetaSame(store, different); found != nil {
+		t.Errorf("Different meta is treated as same")
+	}
+
+	owned := CreateData{URL: "https://example.com", Meta: &CustomMeta{Title: "title"}, CreatedBy: "1"}
+	if found, _ := owned.URL.CheckMetaSame(store, owned); found != nil {
+		t.Errorf("Different owner is treated as same")
+	}
+}
+
+func TestUpdateAndDelete(t *testing.T) {
+	store := NewMemoryStore()
+
+	data := CreateData{URL: "https://example.com", CustomURL: "custom"}
+	urlData, _ := data.CreateShortURL(store)
+
+	newURL := LongURL("https://example.org")
+	if err := (&UpdateData{URL: &newURL, TTL: 60}).Apply(urlData); err != nil {
+		t.Fatalf("Update data is not applied: %v", err)
+	}
+	if err := urlData.Update(store); err != nil {
+		t.Fatalf("Url is not updated: %v", err)
+	}
+	if got, _ := urlData.ShortURL.GetData(store); got.TargetURL != newURL || got.ExpiredAt == nil {
+		t.Errorf("Updated url is not stored")
+	}
+
+	badURL := LongURL("not a url")
+	if err := (&UpdateData{URL: &badURL}).Apply(urlData); err == nil {
+		t.Errorf("Invalid url is not rejected")
+	}
+	// theme colors are not validated, only qr colors are
+	if err := (&UpdateData{Meta: &CustomMeta{ThemeColor: "red"}}).Apply(urlData); err != nil {
+		t.Errorf("Theme color is rejected: %v", err)
+	}
+
+	if err := urlData.ShortURL.Delete(store); err != nil {
+		t.Fatalf("Url is not deleted: %v", err)
+	}
+	if got, _ := urlData.ShortURL.GetData(store); got != nil {
+		t.Errorf("Deleted url is still stored")
+	}
+}
+
+func TestUpdateData(t *testing.T) {
+	expiredAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	urlData := &URLData{ShortURL: "custom", TargetURL: "https://example.com", Meta: &CustomMeta{Title: "title"}, ExpiredAt: &expiredAt, Redirect: 301}
+
+	empty, badRedirect := LongURL(" "), 200
+	invalid := []UpdateData{
+		{Meta: &CustomMeta{}, RemoveMeta: true},
+		{TTL: 60, RemoveExpiry: true},
+		{URL: &empty},
+		{Meta: &CustomMeta{ImageURL: "image"}},
+		{TTL: -1},
+		{Redirect: &badRedirect},
+	}
+	for _, data := range invalid {
+		before := *urlData
+		if err := data.Apply(urlData); err == nil {
+			t.Errorf("Invalid update %+v is not rejected", data)
+		}
+		if *urlData != before {
+			t.Errorf("Rejected update %+v is applied", data)
+		}
+	}
+
+	noRedirect := 0
+	if err := (&UpdateData{RemoveMeta: true, RemoveExpiry: true, Redirect: &noRedirect}).Apply(urlData); err != nil {
+		t.Fatalf("Update data is not applied: %v", err)
+	}
+	if urlData.Meta != nil || urlData.ExpiredAt != nil || urlData.Redirect != 0 || urlData.TargetURL != "https://example.com" {
+		t.Errorf("Updated url is not correct: %+v", urlData)
+	}
+}
+
+func TestDeleteClicks(t *testing.T) {
+	store := NewMemoryStore()
+
+	data := CreateData{URL: "https://example.com", CustomURL: "custom"}
+	urlData, _ := data.CreateShortURL(store)
+	urlData.RecordClick(store, "", "", "127.0.0.1")
+	if err := urlData.ShortURL.Delete(store); err != nil {
+		t.Fatalf("Url is not deleted: %v", err)
+	}
+
+	// a new url with the same id starts without clicks
+	urlData, _ = data.CreateShortURL(store)
+	if stats, _ := urlData.ShortURL.GetClickStats(store, "day", time.Time{}); stats == nil || stats.Count != 0 {
+		t.Errorf("Clicks of deleted url are kept")
+	}
+}
+
+func TestClickStats(t *testing.T) {
+	store := NewMemoryStore()
+
+	data := CreateData{URL: "https://example.com", CustomURL: "custom"}
+	urlData, _ := data.CreateShortURL(store)
+	urlData.RecordClick(store, "https://a.com", "", "127.0.0.1")
+	urlData.RecordClick(store, "https://b.com", "", "127.0.0.1")
+	urlData.RecordClick(store, "https://b.com", "", "127.0.0.1")
+
+	stats, err := urlData.ShortURL.GetClickStats(store, "hour", time.Time{})
+	if err != nil {
+		t.Fatalf("Stats are not returned: %v", err)
+	}
+	if stats.Count != 3 || len(stats.Series) != 1 {
+		t.Errorf("Click series is not correct")
+	}
+	if len(stats.Referrers) != 2 || stats.Referrers[0].Referrer != "https://b.com" {
+		t.Errorf("Top referrers are not correct")
+	}
+
+	if _, err := urlData.ShortURL.GetClickStats(store, "month", time.Time{}); !errors.Is(err, ErrInvalidInterval) {
+		t.Errorf("Invalid interval is not rejected")
+	}
+}